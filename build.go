@@ -0,0 +1,75 @@
+package render
+
+import (
+	"path/filepath"
+	"text/template"
+
+	"github.com/ochipin/render/internal/common"
+)
+
+// BuildResult : Build の結果
+type BuildResult struct {
+	Written []string // 書き込んだファイルのレンダー名
+	Skipped []string // 内容が変更されていないため、書き込まなかったファイルのレンダー名
+}
+
+// Build : Config の設定に従って全てのレンダーファイルを読み込み、解析結果を outDir 以下へ同じ名前で書き込む
+// 読み込むファイルは、Cache = true の場合と同じく、Targets, Binary, MaxSize, SumMaxSize に従う
+// import, partial, layout, cache で参照されるファイル、及びファイル名、またはディレクトリ名が "_" で始まるファイルは、
+// 部品のファイルとして書き込まない
+// バイナリファイルは、そのまま書き込む。内容が変更されていないファイルは、書き込まない
+// data には、レンダー名毎にテンプレートへ渡すデータを返却する関数を指定する(nil = データなし)
+// helpers には、解析時に使用するヘルパを指定する
+func (config *Config) Build(outDir string, data func(string) (interface{}, error), helpers ...template.FuncMap) (*BuildResult, error) {
+	c, fsys, filelist, err := config.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := config.messages()
+	if err != nil {
+		return nil, err
+	}
+	// 読み込んだファイルを元に解析するため、キャッシュありのレンダーオブジェクトを使用する
+	r := c.cacheRender(fsys, messages, filelist)
+	defer r.Close()
+	for _, funcs := range helpers {
+		if err := r.AddHelper(funcs); err != nil {
+			return nil, err
+		}
+	}
+
+	// 部品のファイルは、テンプレートの依存関係から判定する
+	graph, err := config.graph(filelist)
+	if err != nil {
+		return nil, err
+	}
+
+	var result = &BuildResult{}
+	for _, file := range filelist {
+		if common.IsPartial(graph, file.FileName) {
+			continue
+		}
+		var buf = file.FileData
+		if !file.IsBinary {
+			var v interface{}
+			if data != nil {
+				if v, err = data(file.FileName); err != nil {
+					return result, err
+				}
+			}
+			if buf, err = r.Render(file.FileName, v); err != nil {
+				return result, err
+			}
+		}
+		written, err := common.WriteFile(filepath.Join(outDir, filepath.FromSlash(file.FileName)), buf)
+		if err != nil {
+			return result, err
+		}
+		if written {
+			result.Written = append(result.Written, file.FileName)
+		} else {
+			result.Skipped = append(result.Skipped, file.FileName)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+)
+
+func Test_CONFIG_BUILD(t *testing.T) {
+	fsys := fstest.MapFS{
+		"site/index.html":       &fstest.MapFile{Data: []byte(`{{layout "_layout.html"}}{{define "body"}}{{upper .}}{{end}}`)},
+		"site/about/index.html": &fstest.MapFile{Data: []byte(`{{partial "_parts/nav.html" .}}`)},
+		"site/_layout.html":     &fstest.MapFile{Data: []byte(`<body>{{block "body" .}}{{end}}</body>`)},
+		"site/_parts/nav.html":  &fstest.MapFile{Data: []byte(`<nav>{{.}}</nav>`)},
+		"site/image.png":        &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+		"site/style.css":        &fstest.MapFile{Data: []byte(`p{}`)},
+	}
+	out := t.TempDir()
+	conf := &Config{FS: fsys, Directory: "site", Targets: []string{".html", ".png"}, Binary: true}
+	var names []string
+	data := func(name string) (interface{}, error) {
+		names = append(names, name)
+		return name, nil
+	}
+	upper := template.FuncMap{"upper": strings.ToUpper}
+	res, err := conf.Build(out, data, upper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(res.Written, ",") != "about/index.html,image.png,index.html" || len(res.Skipped) != 0 ||
+		strings.Join(names, ",") != "about/index.html,index.html" {
+		t.Fatal(res, names)
+	}
+	for name, expected := range map[string]string{
+		"index.html":       "<body>INDEX.HTML</body>",
+		"about/index.html": "<nav>about/index.html</nav>",
+		"image.png":        "\x89PNG\x00\x01",
+	} {
+		if buf, err := os.ReadFile(filepath.Join(out, name)); err != nil || string(buf) != expected {
+			t.Fatal(name, string(buf), err)
+		}
+	}
+	// 部品のファイル、対象外の拡張子のファイルは書き込まない
+	for _, name := range []string{"_layout.html", "_parts", "style.css"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err == nil {
+			t.Fatal(name)
+		}
+	}
+
+	// 内容が変更されていないファイルは、書き込まない
+	stat, _ := os.Stat(filepath.Join(out, "index.html"))
+	os.Chtimes(filepath.Join(out, "index.html"), stat.ModTime().Add(-time.Hour), stat.ModTime().Add(-time.Hour))
+	fsys["site/about/index.html"] = &fstest.MapFile{Data: []byte(`<p>{{.}}</p>`)}
+	res, err = conf.Build(out, data, upper)
+	if err != nil || strings.Join(res.Written, ",") != "about/index.html" || strings.Join(res.Skipped, ",") != "image.png,index.html" {
+		t.Fatal(res, err)
+	}
+	if after, _ := os.Stat(filepath.Join(out, "index.html")); !after.ModTime().Equal(stat.ModTime().Add(-time.Hour)) {
+		t.Fatal(after.ModTime())
+	}
+	// 一時ファイルは残らない
+	entries, _ := os.ReadDir(out)
+	if len(entries) != 3 {
+		t.Fatal(entries)
+	}
+
+	// データの取得、解析に失敗した場合は、エラーを返却する
+	if _, err := conf.Build(out, func(string) (interface{}, error) { return nil, errors.New("fail") }, upper); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err := conf.Build(out, nil); !errors.Is(err, ErrExec) {
+		t.Fatal(err)
+	}
+
+	// "_" で始まらないファイルも、import, partial, layout, cache で参照される場合は部品のファイルとなる
+	fsys = fstest.MapFS{
+		"site/index.html":        &fstest.MapFile{Data: []byte(`{{layout "layout/base.html"}}{{define "body"}}{{partial "parts/card.html" .}}{{end}}`)},
+		"site/about.html":        &fstest.MapFile{Data: []byte(`{{import "parts/%s.html" "footer"}}`)},
+		"site/layout/base.html":  &fstest.MapFile{Data: []byte(`<body>{{block "body" .}}{{end}}</body>`)},
+		"site/parts/card.html":   &fstest.MapFile{Data: []byte(`<div>{{.}}</div>`)},
+		"site/parts/footer.html": &fstest.MapFile{Data: []byte(`<footer></footer>`)},
+	}
+	out = t.TempDir()
+	conf = &Config{FS: fsys, Directory: "site"}
+	if res, err = conf.Build(out, data); err != nil || strings.Join(res.Written, ",") != "about.html,index.html" {
+		t.Fatal(res, err)
+	}
+	if buf, err := os.ReadFile(filepath.Join(out, "index.html")); err != nil || string(buf) != "<body><div>index.html</div></body>" {
+		t.Fatal(string(buf), err)
+	}
+	for _, name := range []string{"layout", "parts"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err == nil {
+			t.Fatal(name)
+		}
+	}
+	// パースエラーの場合は、エラーを返却する
+	fsys["site/broken.html"] = &fstest.MapFile{Data: []byte(`{{if}}`)}
+	if _, err := conf.Build(out, nil); !errors.Is(err, ErrParse) {
+		t.Fatal(err)
+	}
+}
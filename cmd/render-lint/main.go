@@ -0,0 +1,69 @@
+// render-lint : レンダー対象ディレクトリの全てのレンダーファイルを検証する
+//
+//	render-lint [-dir path] [-targets .html,.txt] [-binary] [-maxsize n] [-summaxsize n] [-helpers name,...] [-messages path]
+//
+// 検出した問題を "ファイル名:行番号:カラム番号: 内容" の形式で出力する
+// 問題を検出した場合は終了コード 1、設定に誤りがある場合は終了コード 2 で終了する
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ochipin/render"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	var config render.Config
+	var dirs, targets, helpers string
+	var left, right string
+
+	flags := flag.NewFlagSet("render-lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&dirs, "dir", ".", "レンダー対象ディレクトリパス。カンマ区切りで指定した場合は、先頭から順に探索する")
+	flags.StringVar(&targets, "targets", "", "レンダー対象となるファイルの拡張子(カンマ区切り)")
+	flags.BoolVar(&config.Binary, "binary", false, "バイナリファイルも扱う")
+	flags.Int64Var(&config.MaxSize, "maxsize", 0, "レンダーファイル1つにつき、最大で扱えるファイルサイズ")
+	flags.Int64Var(&config.SumMaxSize, "summaxsize", 0, "レンダーファイルの合計最大サイズ")
+	flags.StringVar(&helpers, "helpers", "", "登録するヘルパの関数名(カンマ区切り)")
+	flags.StringVar(&config.Messages, "messages", "", "メッセージカタログを格納したディレクトリパス")
+	flags.StringVar(&left, "left", "", "テンプレートの開始区切り文字")
+	flags.StringVar(&right, "right", "", "テンプレートの終了区切り文字")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config.Directories = split(dirs)
+	config.Targets = split(targets)
+	config.Delims = render.Delims{Left: left, Right: right}
+	issues, err := config.Lint(split(helpers)...)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	for _, issue := range issues {
+		fmt.Fprintln(stdout, issue)
+	}
+	if len(issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// カンマ区切りの文字列を分割する
+func split(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
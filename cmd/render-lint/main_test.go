@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_RUN(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte(`{{import "header.html"}}{{upper .}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "header.html"), []byte(`<h1></h1>`), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-dir", dir, "-helpers", "upper"}, &stdout, &stderr); code != 0 || stdout.Len() != 0 {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+	// 問題を検出した場合は、終了コード 1 となる
+	os.Remove(filepath.Join(dir, "header.html"))
+	if code := run([]string{"-dir", dir}, &stdout, &stderr); code != 1 ||
+		stdout.String() != "index.html:1:2: import: template \"header.html\" not defined\nindex.html:1:26: function \"upper\" not defined\n" {
+		t.Fatal(code, stdout.String())
+	}
+	// 設定に誤りがある場合は、終了コード 2 となる
+	if code := run([]string{"-dir", filepath.Join(dir, "undefined")}, &stdout, &stderr); code != 2 {
+		t.Fatal(code)
+	}
+	if code := run([]string{"-undefined"}, &stdout, &stderr); code != 2 {
+		t.Fatal(code)
+	}
+}
@@ -0,0 +1,327 @@
+// render : テンプレートファイルを解析し、結果を出力する
+//
+//	render [flags] name
+//	render [flags] -string text
+//	render build [flags] outdir
+//	render graph [flags] [-format dot|json]
+//
+// 設定は -config で指定した JSON ファイル(render.Config と同じ項目名)から読み込み、フラグで上書きする
+// テンプレートへ渡すデータは、-data で指定した JSON ファイルから読み込む。"-" を指定した場合は、標準入力から読み込む
+// ヘルパには、helper.Funcs() を登録する。-env を指定した場合は、helper.EnvFuncs() も登録する
+//
+// build を指定した場合は、全てのレンダーファイルの解析結果を outdir へ書き込む(render.Config.Build)
+// graph を指定した場合は、テンプレートの依存関係を出力する(render.Config.Graph)
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/ochipin/render"
+	"github.com/ochipin/render/core"
+	"github.com/ochipin/render/helper"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "build" {
+		return build(args[1:], stdin, stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "graph" {
+		return graph(args[1:], stdin, stdout, stderr)
+	}
+
+	flags, opts := newFlags("render", stderr)
+	var output, text, locale string
+	flags.StringVar(&output, "o", "", "出力先ファイル(空文字 = 標準出力)")
+	flags.StringVar(&text, "string", "", "解析するテンプレート文字列。指定した場合は RenderString を使用する")
+	flags.StringVar(&locale, "locale", "", "ロケール。指定した場合は RenderLocale を使用する")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: render [flags] name\n       render [flags] -string text\n       render build [flags] outdir\n       render graph [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if (flags.NArg() != 1 && text == "") || (flags.NArg() != 0 && text != "") {
+		flags.Usage()
+		return 2
+	}
+	config, data, err := opts.load(flags, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	r, err := config.New()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	defer r.Close()
+	for _, funcs := range opts.helpers() {
+		if err := r.AddHelper(funcs); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+
+	var buf []byte
+	switch {
+	case text != "":
+		buf, err = r.RenderString(text, data)
+	case locale != "":
+		buf, err = r.RenderLocale(flags.Arg(0), locale, data)
+	default:
+		buf, err = r.Render(flags.Arg(0), data)
+	}
+	if err != nil {
+		printError(stderr, err)
+		return 1
+	}
+
+	if output == "" {
+		stdout.Write(buf)
+		return 0
+	}
+	if err := os.WriteFile(output, buf, 0644); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// render build : 全てのレンダーファイルの解析結果を、出力先ディレクトリへ書き込む
+// 書き込んだファイルのレンダー名を出力する
+func build(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags, opts := newFlags("render build", stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: render build [flags] outdir")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	config, data, err := opts.load(flags, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	result, err := config.Build(flags.Arg(0), func(string) (interface{}, error) {
+		return data, nil
+	}, opts.helpers()...)
+	if result != nil {
+		for _, name := range result.Written {
+			fmt.Fprintln(stdout, name)
+		}
+	}
+	if err != nil {
+		printError(stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// render graph : テンプレートの依存関係を DOT, または JSON 形式で出力する
+func graph(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags, opts := newFlags("render graph", stderr)
+	var format string
+	flags.StringVar(&format, "format", "dot", "出力形式(dot, json)")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: render graph [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 || (format != "dot" && format != "json") {
+		flags.Usage()
+		return 2
+	}
+	config, _, err := opts.load(flags, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+	g, err := config.Graph()
+	if err != nil {
+		printError(stderr, err)
+		return 1
+	}
+	if format == "json" {
+		buf, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintln(stdout, string(buf))
+		return 0
+	}
+	fmt.Fprint(stdout, g.DOT())
+	return 0
+}
+
+// 設定、データの読み込みに使用するフラグ
+type options struct {
+	configfile, datafile                             string
+	dirs, targets, escape, exclude, left, right, msg string
+	binary, env                                      bool
+	maxsize, summaxsize                              int64
+}
+
+// 設定、データの読み込みに使用するフラグを定義する
+func newFlags(name string, stderr io.Writer) (*flag.FlagSet, *options) {
+	var opts options
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.StringVar(&opts.configfile, "config", "", "設定ファイル(JSON)")
+	flags.StringVar(&opts.datafile, "data", "", "テンプレートへ渡すデータ(JSON)。\"-\" = 標準入力")
+	flags.StringVar(&opts.dirs, "dir", "", "レンダー対象ディレクトリパス。カンマ区切りで指定した場合は、先頭から順に探索する")
+	flags.StringVar(&opts.targets, "targets", "", "レンダー対象となるファイルの拡張子(カンマ区切り)")
+	flags.StringVar(&opts.escape, "escape", "", "html/template で自動エスケープする拡張子(カンマ区切り)")
+	flags.StringVar(&opts.exclude, "exclude", "", "レンダーファイル内の除外文字列(正規表現)")
+	flags.BoolVar(&opts.binary, "binary", false, "バイナリファイルも扱う")
+	flags.Int64Var(&opts.maxsize, "maxsize", 0, "レンダーファイル1つにつき、最大で扱えるファイルサイズ")
+	flags.Int64Var(&opts.summaxsize, "summaxsize", 0, "レンダーファイルの合計最大サイズ")
+	flags.StringVar(&opts.left, "left", "", "テンプレートの開始区切り文字")
+	flags.StringVar(&opts.right, "right", "", "テンプレートの終了区切り文字")
+	flags.StringVar(&opts.msg, "messages", "", "メッセージカタログを格納したディレクトリパス")
+	flags.BoolVar(&opts.env, "env", false, "環境変数を参照する env 関数を登録する")
+	return flags, &opts
+}
+
+// 登録するヘルパを返却する
+func (opts *options) helpers() []template.FuncMap {
+	if opts.env {
+		return []template.FuncMap{helper.Funcs(), helper.EnvFuncs()}
+	}
+	return []template.FuncMap{helper.Funcs()}
+}
+
+// 設定ファイルを読み込み、指定したフラグで上書きした設定と、テンプレートへ渡すデータを返却する
+func (opts *options) load(flags *flag.FlagSet, stdin io.Reader) (*render.Config, interface{}, error) {
+	var config render.Config
+	if opts.configfile != "" {
+		if err := readJSON(opts.configfile, nil, &config); err != nil {
+			return nil, nil, err
+		}
+	}
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "dir":
+			config.Directories = split(opts.dirs)
+		case "targets":
+			config.Targets = split(opts.targets)
+		case "escape":
+			config.Escape = split(opts.escape)
+		case "exclude":
+			if config.Exclude, err = regexp.Compile(opts.exclude); err != nil {
+				err = fmt.Errorf("exclude: %w", err)
+			}
+		case "binary":
+			config.Binary = opts.binary
+		case "maxsize":
+			config.MaxSize = opts.maxsize
+		case "summaxsize":
+			config.SumMaxSize = opts.summaxsize
+		case "left":
+			config.Delims.Left = opts.left
+		case "right":
+			config.Delims.Right = opts.right
+		case "messages":
+			config.Messages = opts.msg
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// テンプレートへ渡すデータを読み込む
+	var data interface{}
+	if opts.datafile != "" {
+		if err := readJSON(opts.datafile, stdin, &data); err != nil {
+			return nil, nil, err
+		}
+		data = normalize(data)
+	}
+	return &config, data, nil
+}
+
+// 解析エラーの場合は、エラー発生箇所を出力する
+func printError(stderr io.Writer, err error) {
+	var rerr *core.RenderError
+	if errors.As(err, &rerr) {
+		fmt.Fprint(stderr, rerr.Detail())
+		return
+	}
+	fmt.Fprintln(stderr, err)
+}
+
+// JSON ファイルを読み込む。name が "-" の場合は stdin から読み込む
+// YAML は外部パッケージが必要となるため対応せず、拡張子が .yaml, .yml の場合はエラーとする
+func readJSON(name string, stdin io.Reader, v interface{}) error {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return fmt.Errorf("%s: YAML is not supported. use JSON", name)
+	}
+	var r = stdin
+	if name != "-" || stdin == nil {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// JSON の数値は float64 となるため、整数の場合は int へ変換し、サービスで渡すデータと同じ型で解析できるようにする
+func normalize(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int(v)
+		}
+	}
+	return v
+}
+
+// カンマ区切りの文字列を分割する
+func split(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
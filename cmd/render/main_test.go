@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_RUN(t *testing.T) {
+	dir := t.TempDir()
+	views := filepath.Join(dir, "views")
+	os.Mkdir(views, 0755)
+	os.WriteFile(filepath.Join(views, "app.conf"), []byte("# {{.Comment}}\nname = {{.Name | upper}}\nport = {{add .Port 1}}\n//= kept"), 0644)
+	os.WriteFile(filepath.Join(views, "error.conf"), []byte("line1\n{{.Name.Value}}\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "data.json"), []byte(`{"Name": "app", "Port": 8080, "Comment": "<b>"}`), 0644)
+	os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"Directory": "`+filepath.ToSlash(views)+`", "Exclude": "(^|\\n)//=\\s*(.+?)\\s*$"}`), 0644)
+
+	// 設定ファイル、データファイルを指定して、標準出力へ出力する。除外文字列の設定も適用する
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-config", filepath.Join(dir, "config.json"), "-data", filepath.Join(dir, "data.json"), "app.conf"}, nil, &stdout, &stderr)
+	if code != 0 || stdout.String() != "# <b>\nname = APP\nport = 8081\nkept" {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+
+	// フラグで設定を上書きし、標準入力のデータを使用して、ファイルへ出力する
+	output := filepath.Join(dir, "out.conf")
+	code = run([]string{"-config", filepath.Join(dir, "config.json"), "-escape", ".conf", "-data", "-", "-o", output, "app.conf"},
+		strings.NewReader(`{"Name": "x", "Port": 1, "Comment": "<b>"}`), &stdout, &stderr)
+	if buf, _ := os.ReadFile(output); code != 0 || string(buf) != "# &lt;b&gt;\nname = X\nport = 2\nkept" {
+		t.Fatal(code, string(buf), stderr.String())
+	}
+
+	// YAML の設定ファイル、データファイルには対応していない
+	os.WriteFile(filepath.Join(dir, "data.yml"), []byte("Name: app\n"), 0644)
+	stderr.Reset()
+	code = run([]string{"-dir", views, "-data", filepath.Join(dir, "data.yml"), "app.conf"}, nil, &stdout, &stderr)
+	if code != 2 || !strings.Contains(stderr.String(), "YAML is not supported") {
+		t.Fatal(code, stderr.String())
+	}
+
+	// env は、-env を指定した場合のみ使用できる
+	t.Setenv("RENDER_CLI_TEST", "value")
+	stdout.Reset()
+	if code := run([]string{"-dir", views, "-env", "-string", `{{env "RENDER_CLI_TEST"}}`}, nil, &stdout, &stderr); code != 0 || stdout.String() != "value" {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+	if code := run([]string{"-dir", views, "-string", `{{env "RENDER_CLI_TEST"}}`}, nil, &stdout, &stderr); code != 1 {
+		t.Fatal(code)
+	}
+
+	// テンプレート文字列を解析する
+	stdout.Reset()
+	if code := run([]string{"-dir", views, "-string", `{{"a" | upper}}`}, nil, &stdout, &stderr); code != 0 || stdout.String() != "A" {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+
+	// 解析エラーの場合は、エラー発生箇所を出力し、終了コード 1 となる
+	stderr.Reset()
+	if code := run([]string{"-dir", views, "-data", filepath.Join(dir, "data.json"), "error.conf"}, nil, &stdout, &stderr); code != 1 ||
+		!strings.Contains(stderr.String(), "> 2 | {{.Name.Value}}") {
+		t.Fatal(code, stderr.String())
+	}
+
+	// 引数、設定に誤りがある場合は、終了コード 2 となる
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"Name": `), 0644)
+	for _, args := range [][]string{
+		{},
+		{"-string", "a", "app.conf"},
+		{"-undefined", "app.conf"},
+		{"-dir", filepath.Join(dir, "undefined"), "app.conf"},
+		{"-dir", views, "-data", filepath.Join(dir, "undefined.json"), "app.conf"},
+		{"-dir", views, "-data", filepath.Join(dir, "broken.json"), "app.conf"},
+		{"-dir", views, "-exclude", "(", "app.conf"},
+	} {
+		if code := run(args, nil, &stdout, &stderr); code != 2 {
+			t.Fatal(args, code)
+		}
+	}
+}
+
+func Test_RUN_BUILD(t *testing.T) {
+	dir := t.TempDir()
+	views := filepath.Join(dir, "views")
+	os.MkdirAll(filepath.Join(views, "_parts"), 0755)
+	os.WriteFile(filepath.Join(views, "index.html"), []byte(`{{partial "_parts/title.html" .Title}}`), 0644)
+	os.WriteFile(filepath.Join(views, "_parts", "title.html"), []byte(`<h1>{{. | upper}}</h1>`), 0644)
+	os.WriteFile(filepath.Join(dir, "data.json"), []byte(`{"Title": "top"}`), 0644)
+
+	out := filepath.Join(dir, "public")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", "-dir", views, "-data", filepath.Join(dir, "data.json"), out}, nil, &stdout, &stderr); code != 0 ||
+		stdout.String() != "index.html\n" {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+	if buf, _ := os.ReadFile(filepath.Join(out, "index.html")); string(buf) != "<h1>TOP</h1>" {
+		t.Fatal(string(buf))
+	}
+	// 内容が変更されていない場合は、書き込まない
+	stdout.Reset()
+	if code := run([]string{"build", "-dir", views, "-data", filepath.Join(dir, "data.json"), out}, nil, &stdout, &stderr); code != 0 ||
+		stdout.Len() != 0 {
+		t.Fatal(code, stdout.String())
+	}
+	if code := run([]string{"build", "-dir", views}, nil, &stdout, &stderr); code != 2 {
+		t.Fatal(code)
+	}
+	os.WriteFile(filepath.Join(views, "error.html"), []byte(`{{.Title.Value}}`), 0644)
+	if code := run([]string{"build", "-dir", views, out}, nil, &stdout, &stderr); code != 1 {
+		t.Fatal(code)
+	}
+}
+
+func Test_RUN_GRAPH(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte(`{{import "header.html"}}`), 0644)
+	os.WriteFile(filepath.Join(dir, "header.html"), []byte(`<h1></h1>`), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"graph", "-dir", dir}, nil, &stdout, &stderr); code != 0 ||
+		!strings.Contains(stdout.String(), `"index.html" -> "header.html" [label="import"];`) {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+	stdout.Reset()
+	if code := run([]string{"graph", "-dir", dir, "-format", "json"}, nil, &stdout, &stderr); code != 0 ||
+		!strings.Contains(stdout.String(), `"to": "header.html"`) {
+		t.Fatal(code, stdout.String(), stderr.String())
+	}
+	if code := run([]string{"graph", "-format", "svg"}, nil, &stdout, &stderr); code != 2 {
+		t.Fatal(code)
+	}
+	os.WriteFile(filepath.Join(dir, "broken.html"), []byte(`{{if}}`), 0644)
+	if code := run([]string{"graph", "-dir", dir}, nil, &stdout, &stderr); code != 1 {
+		t.Fatal(code)
+	}
+}
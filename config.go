@@ -1,11 +1,13 @@
 package render
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/ochipin/render/internal/nocache"
 
@@ -17,69 +19,236 @@ import (
 // Render : core.Render のエイリアス
 type Render = core.Render
 
+// Delims : テンプレートの区切り文字
+type Delims = common.Delims
+
 // Config : Renderインタフェースを構築する設定用構造体
 type Config struct {
-	Directory  string         // レンダー対象ディレクトリパス
-	Targets    []string       // レンダー対象となるファイルの拡張子
-	Exclude    *regexp.Regexp // レンダーファイル内の除外文字列
-	Cache      bool           // true = オンメモリ, false = ディスク
-	Binary     bool           // true = バイナリも扱う, false = バイナリは扱わない
-	MaxSize    int64          // レンダーファイル1つにつき、最大で扱えるファイルサイズ
-	SumMaxSize int64          // レンダーファイルの合計最大サイズ(Cache = true の時のみ有効)
+	Directory   string            // レンダー対象ディレクトリパス
+	Directories []string          // 先頭から順に探索するレンダー対象ディレクトリパス。指定した場合、Directory は使用しない
+	FS          fs.FS             // レンダー対象ファイルの読み込み元(nil = Directory を OS のファイルシステムから読み込む)
+	Targets     []string          // レンダー対象となるファイルの拡張子
+	Exclude     *regexp.Regexp    // レンダーファイル内の除外文字列
+	Escape      []string          // html/template で自動エスケープする拡張子("*" = 全ファイル)
+	Cache       bool              // true = オンメモリ, false = ディスク
+	Binary      bool              // true = バイナリも扱う, false = バイナリは扱わない
+	MaxSize     int64             // レンダーファイル1つにつき、最大で扱えるファイルサイズ
+	SumMaxSize  int64             // レンダーファイルの合計最大サイズ(Cache = true の時のみ有効)
+	Delims      Delims            // テンプレートの区切り文字(空文字 = "{{", "}}")
+	ExtDelims   map[string]Delims // 拡張子毎のテンプレートの区切り文字。Delims より優先する
+	Watch       time.Duration     // ファイルの変更を監視する間隔(0 = 監視しない。Cache = true の時のみ有効)
+	OnReload    func(error)       // 監視による再読み込みの結果を受け取る関数(nil = 通知しない)
+	Compress    bool              // true = gzip で圧縮したデータも作成する(Cache = true の時のみ有効)
+	Messages    string            // メッセージカタログ(ロケール名.json)を格納したディレクトリパス(空文字 = t, tn を使用しない)
+	Locale      string            // ロケール未指定時、及びメッセージが見つからない場合に使用するロケール
+	Fragments   int               // cache 関数で保持する解析結果の最大件数(0 = 1000)
 }
 
 // New : Renderインタフェースを生成する
 func (config *Config) New() (Render, error) {
 	var result core.Render
 
-	// ディレクトリが未指定の場合、カレントディレクトリを対象とする
-	if config.Directory == "" {
-		config.Directory = "."
-	}
-	// 指定したパスが存在しない、またはディレクトリではない場合、エラーとする
-	f, err := os.Stat(config.Directory)
+	// レンダーファイルの読み込み元を取得する
+	fsys, err := config.filesystem()
 	if err != nil {
-		return nil, fmt.Errorf("cannot access '%s' no such file or directory", config.Directory)
+		return nil, err
 	}
-	if f.IsDir() == false {
-		return nil, fmt.Errorf("cannot access '%s' not directory", config.Directory)
+	// メッセージカタログの読み込み元を取得する
+	messages, err := config.messages()
+	if err != nil {
+		return nil, err
 	}
 
 	if config.Cache {
 		// オンメモリの場合、キャッシュファイルリストを生成
-		filelist, err := config.cacheFilelist()
+		filelist, err := config.cacheFilelist(fsys)
 		if err != nil {
 			return nil, err
 		}
 		// レンダーオブジェクトを生成
-		result = cache.CreateRender(&common.Config{
-			Directory: strings.TrimRight(config.Directory, "/"),
-			Exclude:   config.Exclude,
-			Files:     filelist,
-		})
+		result = config.cacheRender(fsys, messages, filelist)
 	} else {
 		// ディスクの場合
 		result = nocache.CreateRender(&common.Config{
 			Directory: strings.TrimRight(config.Directory, "/"),
+			FS:        fsys,
 			Targets:   config.Targets,
 			Exclude:   config.Exclude,
+			Escape:    config.Escape,
 			MaxSize:   config.MaxSize,
 			Binary:    config.Binary,
+			Delims:    config.Delims,
+			ExtDelims: config.ExtDelims,
+			Messages:  messages,
+			Locale:    config.Locale,
+			Fragments: config.Fragments,
 		})
 	}
 
 	return result, nil
 }
 
+// 作成済みのファイルリストから、キャッシュありのレンダーオブジェクトを生成する
+func (config *Config) cacheRender(fsys *common.RootFS, messages fs.FS, filelist []*common.File) core.Render {
+	// 再読み込み時は、生成時点の設定でファイルリストを再作成する
+	var c = *config
+	return cache.CreateRender(&common.Config{
+		Directory: strings.TrimRight(config.Directory, "/"),
+		FS:        fsys,
+		Exclude:   config.Exclude,
+		Escape:    config.Escape,
+		Delims:    config.Delims,
+		ExtDelims: config.ExtDelims,
+		Files:     filelist,
+		Loader: func() ([]*common.File, error) {
+			return c.cacheFilelist(fsys)
+		},
+		Watch:     config.Watch,
+		OnReload:  config.OnReload,
+		Messages:  messages,
+		Locale:    config.Locale,
+		Fragments: config.Fragments,
+	})
+}
+
+// メッセージカタログの読み込み元となる fs.FS を返却する。Messages が未指定の場合は nil を返却する
+func (config *Config) messages() (fs.FS, error) {
+	if config.Messages == "" {
+		return nil, nil
+	}
+	return config.root(config.Messages)
+}
+
+// レンダーファイルの読み込み元となる fs.FS を返却する。New, Lint, Build, Graph で使用する
+// Directories が指定されている場合は、先頭のディレクトリから順に探索する fs.FS を返却する
+func (config *Config) filesystem() (*common.RootFS, error) {
+	// ディレクトリが未指定の場合、カレントディレクトリを対象とする
+	if config.Directory == "" {
+		config.Directory = "."
+	}
+	var dirs = config.Directories
+	if len(dirs) == 0 {
+		dirs = []string{config.Directory}
+	}
+	var roots []fs.FS
+	for _, dir := range dirs {
+		root, err := config.root(dir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, root)
+	}
+	return common.NewRootFS(dirs, roots), nil
+}
+
+// レンダーファイルの読み込み元と、読み込み元の全てのレンダーファイルを返却する。Build, Graph で使用する
+// 返却する設定は、監視、圧縮データの作成を無効にした config のコピーとなる
+func (config *Config) snapshot() (*Config, *common.RootFS, []*common.File, error) {
+	fsys, err := config.filesystem()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var c = *config
+	c.Watch, c.Compress = 0, false
+	filelist, err := c.cacheFilelist(fsys)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return &c, fsys, filelist, nil
+}
+
+// 指定したディレクトリの fs.FS を返却する
+func (config *Config) root(directory string) (fs.FS, error) {
+	// FS が未指定の場合は、directory を OS のファイルシステムから読み込む
+	if config.FS == nil {
+		// 指定したパスが存在しない、またはディレクトリではない場合、エラーとする
+		f, err := os.Stat(directory)
+		if err != nil {
+			return nil, notDirectory(directory, err)
+		}
+		if f.IsDir() == false {
+			return nil, notDirectory(directory, &fs.PathError{Op: "stat", Path: directory, Err: errNotDir})
+		}
+		return os.DirFS(directory), nil
+	}
+
+	// FS が指定されている場合は、FS 内の directory を対象とする
+	dir := strings.Trim(directory, "/")
+	if dir == "" {
+		dir = "."
+	}
+	f, err := fs.Stat(config.FS, dir)
+	if err != nil {
+		return nil, notDirectory(directory, err)
+	}
+	if f.IsDir() == false {
+		return nil, notDirectory(directory, &fs.PathError{Op: "stat", Path: dir, Err: errNotDir})
+	}
+	return fs.Sub(config.FS, dir)
+}
+
+// ディレクトリではないパスを指定した場合の、元のエラー
+var errNotDir = errors.New("not a directory")
+
+// 存在しない、またはディレクトリではないパスを指定した場合のエラーを生成する
+func notDirectory(directory string, err error) error {
+	return &core.TemplateError{
+		Message:  fmt.Sprintf("cannot access '%s': %v", directory, err),
+		Name:     directory,
+		Category: core.ErrNotFound,
+		Err:      err,
+	}
+}
+
 // Directory に指定したパス直下にある全ファイル一覧を取得し、レンダーファイルの元データを作成する
-func (config *Config) cacheFilelist() ([]*common.File, error) {
+func (config *Config) cacheFilelist(fsys *common.RootFS) ([]*common.File, error) {
 	var filelist []*common.File
 	var sumfilesize int64
 
 	// 指定されたディレクトリ直下にあるファイル一覧を取得する
-	err := filepath.Walk(config.Directory, func(path string, f os.FileInfo, err error) error {
+	err := config.walk(fsys, func(path string, f *common.File, err error) error {
+		// ディレクトリ、ファイルを読み込めない場合、ファイルサイズが設定値を超過していた場合は、エラーとする
+		if err != nil {
+			return err
+		}
+		// ファイルリストに、取得したファイル情報を追加
+		filelist = append(filelist, f)
+		// ファイルサイズの合計値を求める
+		sumfilesize += int64(len(f.FileData))
+		// 圧縮データを作成する場合は、圧縮データのサイズも合計値に含める
+		if config.Compress {
+			f.Encoded = common.Compress(f, common.DelimsOf(path, config.Delims, config.ExtDelims), common.IsEscape(path, config.Escape), config.Exclude)
+			for _, v := range f.Encoded {
+				sumfilesize += int64(len(v))
+			}
+		}
+		return nil
+	})
+	// ファイルサイズの合計値が、設定値であるSumMaxSizeを超過していないかチェック
+	if config.SumMaxSize > 0 && sumfilesize > config.SumMaxSize {
+		return nil, &core.TemplateError{
+			Message:  fmt.Sprintf("%s: %d < %d. sum maxsize over", config.Directory, config.SumMaxSize, sumfilesize),
+			Name:     config.Directory,
+			Category: core.ErrTooLarge,
+			Limit:    config.SumMaxSize,
+			Size:     sumfilesize,
+		}
+	}
+
+	// ファイル一覧を返却する
+	return filelist, err
+}
+
+// Targets, Binary, MaxSize に従ってレンダーファイルを読み込み、ファイル毎に fn を呼び出す。New, Lint で使用する
+// 読み込めないディレクトリ、ファイル、MaxSize を超過したファイルは、err を指定して呼び出す
+// fn がエラーを返却した場合は、読み込みを中止する
+func (config *Config) walk(fsys *common.RootFS, fn func(path string, f *common.File, err error) error) error {
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fn(path, nil, err)
+		}
 		// ディレクトリの場合はスルー
-		if f.IsDir() {
+		if d.IsDir() {
 			return nil
 		}
 		// 登録済みの拡張子と一致しない場合は、スルー
@@ -87,10 +256,9 @@ func (config *Config) cacheFilelist() ([]*common.File, error) {
 			return nil
 		}
 		// ファイルを読み込む
-		file, err := common.ReadFile(path)
-		// パーミッション等の理由でファイルが読み込み出来ない場合は、エラーとする
+		file, err := common.OpenFile(fsys, path)
 		if err != nil {
-			return err
+			return fn(path, nil, err)
 		}
 		defer file.Close()
 		// バイナリファイルを対象としていない場合、スルー
@@ -98,25 +266,21 @@ func (config *Config) cacheFilelist() ([]*common.File, error) {
 		if config.Binary == false && isBinary == true {
 			return nil
 		}
-		// ファイルサイズが設定値を超過していた場合、エラーを返却する
-		if config.MaxSize > 0 && f.Size() > config.MaxSize {
-			return fmt.Errorf("%s: %d < %d. maxsize over", path, config.MaxSize, f.Size())
+		size := file.Size()
+		if config.MaxSize > 0 && size > config.MaxSize {
+			return fn(path, nil, common.TooLarge(path, config.MaxSize, size))
 		}
-		// ファイルリストに、取得したファイル情報を追加
-		filelist = append(filelist, &common.File{
+		// ファイルを読み込んだディレクトリを取得する
+		origin, err := fsys.Origin(path)
+		if err != nil {
+			return fn(path, nil, err)
+		}
+		return fn(path, &common.File{
 			FileData: file.ReadAll(),
-			FileName: common.ToWindowsPath(path[len(config.Directory)+1:]),
+			FileName: path,
+			Origin:   origin,
+			ModTime:  file.ModTime(),
 			IsBinary: isBinary,
-		})
-		// ファイルサイズの合計値を求める
-		sumfilesize += f.Size()
-		return nil
+		}, nil)
 	})
-	// ファイルサイズの合計値が、設定値であるSumMaxSizeを超過していないかチェック
-	if config.SumMaxSize > 0 && sumfilesize > config.SumMaxSize {
-		return nil, fmt.Errorf("%s: %d < %d. sum maxsize over", config.Directory, config.SumMaxSize, sumfilesize)
-	}
-
-	// ファイル一覧を返却する
-	return filelist, err
 }
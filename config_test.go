@@ -1,6 +1,17 @@
 package render
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+
+	"github.com/ochipin/render/core"
+)
 
 func Test_CONFIG_NEW_ERROR(t *testing.T) {
 	var conf = &Config{
@@ -13,28 +24,32 @@ func Test_CONFIG_NEW_ERROR(t *testing.T) {
 		SumMaxSize: 3000,
 	}
 	// 存在しないディレクトリを指定したため、エラーとなる
-	if _, err := conf.New(); err == nil {
-		t.Fatal("Error")
+	var terr *core.TemplateError
+	if _, err := conf.New(); !errors.As(err, &terr) || !errors.Is(err, core.ErrNotFound) || !errors.Is(err, fs.ErrNotExist) || terr.Name != "/nodir" {
+		t.Fatal(err)
 	}
 
 	conf.Directory = "config.go"
 	// ディレクトリではないファイルを指定したため、エラーとなる
-	if _, err := conf.New(); err == nil {
-		t.Fatal("Error")
+	if _, err := conf.New(); !errors.Is(err, core.ErrNotFound) {
+		t.Fatal(err)
+	}
+	if _, err := (&Config{FS: fstest.MapFS{"a.html": &fstest.MapFile{}}, Directory: "a.html"}).New(); !errors.Is(err, core.ErrNotFound) {
+		t.Fatal(err)
 	}
 
 	// カレントディレクトリを対象とする
 	conf.Directory = ""
 	// 合計ファイルサイズが、SumMaxSizeを超過しているため、エラーとなる
-	if _, err := conf.New(); err == nil {
-		t.Fatal("Error")
+	if _, err := conf.New(); !errors.As(err, &terr) || !errors.Is(err, core.ErrTooLarge) || terr.Limit != 3000 || terr.Size <= 3000 {
+		t.Fatal(err)
 	}
 
 	conf.SumMaxSize = 0
 	conf.MaxSize = 100
 	// 単体ファイルサイズが、MaxSizeを超過しているため、エラーとなる
-	if _, err := conf.New(); err == nil {
-		t.Fatal("Error")
+	if _, err := conf.New(); !errors.As(err, &terr) || !errors.Is(err, core.ErrTooLarge) || terr.Limit != 100 || terr.Size <= 100 {
+		t.Fatal(err)
 	}
 }
 
@@ -58,3 +73,439 @@ func Test_CONFIG_NEW_SUCCESS(t *testing.T) {
 		t.Fatal("Error")
 	}
 }
+
+func Test_CONFIG_NEW_FS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/app/index.html": &fstest.MapFile{Data: []byte(`{{if hastemplate "app/body.text"}}{{import "app/body.text"}}{{end}}`)},
+		"views/app/body.text":  &fstest.MapFile{Data: []byte(`{{.}}`)},
+		"views/app/image.png":  &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+	}
+	var conf = &Config{
+		FS:        fsys,
+		Directory: "views",
+		Binary:    true,
+	}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// FS 内の Directory 配下のファイルを、Directory からの相対パスで取得する
+		buf, err := r.Render("app/index.html", "Hello World")
+		if err != nil || string(buf) != "Hello World" {
+			t.Fatal(string(buf), err)
+		}
+		// バイナリファイルも FS から判定する
+		buf, err = r.Render("app/image.png", nil)
+		if err != nil || len(buf) != 6 {
+			t.Fatal(err)
+		}
+	}
+	// FS 内に存在しないディレクトリを指定した場合、エラーとなる
+	conf.Directory = "undefined"
+	if _, err := conf.New(); err == nil {
+		t.Fatal("Error")
+	}
+	// ディレクトリではないファイルを指定した場合、エラーとなる
+	conf.Directory = "views/app/index.html"
+	if _, err := conf.New(); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func Test_CONFIG_RELOAD(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`v1`)},
+	}
+	var conf = &Config{
+		FS:         fsys,
+		Cache:      true,
+		SumMaxSize: 16,
+	}
+	r, err := conf.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2 := r.Copy()
+	version := r.Version()
+	if version == "" {
+		t.Fatal("Error")
+	}
+
+	// 再読み込みすると、コピーしたレンダーオブジェクトにも反映され、バージョンが変わる
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`v2`)}
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if buf, err := r2.Render("index.html", nil); err != nil || string(buf) != "v2" {
+		t.Fatal(string(buf), err)
+	}
+	if r.Version() == version || r.Version() != r2.Version() {
+		t.Fatal("Error")
+	}
+	version = r.Version()
+	// 内容が同じ場合、バージョンは変わらない
+	if err := r.Reload(); err != nil || r.Version() != version {
+		t.Fatal("Error", err)
+	}
+
+	// SumMaxSize を超過した場合はエラーとなり、再読み込み前のファイルを使用し続ける
+	fsys["other.html"] = &fstest.MapFile{Data: []byte(`0123456789abcdef`)}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Error")
+	}
+	delete(fsys, "other.html")
+	// 構文エラーの場合も、再読み込み前のファイルを使用し続ける
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`{{`)}
+	if err := r.Reload(); err == nil {
+		t.Fatal("Error")
+	}
+	if buf, err := r.Render("index.html", nil); err != nil || string(buf) != "v2" || r.Version() != version {
+		t.Fatal(string(buf), err)
+	}
+
+	// キャッシュなしの場合、再読み込みは不要のため何もしない
+	conf.Cache = false
+	r, err = conf.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err != nil || r.Version() != "" {
+		t.Fatal("Error", err)
+	}
+}
+
+func Test_CONFIG_DELIMS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<%partial "app.vue" .%>`)},
+		"app.vue":    &fstest.MapFile{Data: []byte(`<p>{{ msg }}</p>[[.]]`)},
+	}
+	var conf = &Config{
+		FS:        fsys,
+		Delims:    Delims{Left: "<%", Right: "%>"},
+		ExtDelims: map[string]Delims{".vue": {Left: "[[", Right: "]]"}},
+	}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 拡張子毎の区切り文字が優先され、{{ }} はそのまま出力される
+		buf, err := r.Render("index.html", "Hello")
+		if err != nil || string(buf) != "<p>{{ msg }}</p>Hello" {
+			t.Fatal(string(buf), err)
+		}
+		// 文字列レンダーは、全体の区切り文字を使用する
+		buf, err = r.RenderString(`{{.}}<%.%>`, "Hello")
+		if err != nil || string(buf) != "{{.}}Hello" {
+			t.Fatal(string(buf), err)
+		}
+	}
+}
+
+func Test_CONFIG_DIRECTORIES(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/index.html":    &fstest.MapFile{Data: []byte(`app{{if hastemplate "footer.html"}}{{import "footer.html"}}{{end}}`)},
+		"theme/index.html":  &fstest.MapFile{Data: []byte(`theme`)},
+		"theme/footer.html": &fstest.MapFile{Data: []byte(`-footer`)},
+	}
+	var conf = &Config{
+		FS:          fsys,
+		Directories: []string{"app", "theme"},
+	}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 先頭のディレクトリのファイルが優先され、import も同じ順序で探索する
+		buf, err := r.Render("index.html", nil)
+		if err != nil || string(buf) != "app-footer" {
+			t.Fatal(string(buf), err)
+		}
+		// ファイルを読み込んだディレクトリを取得する
+		if v, err := r.Origin("index.html"); err != nil || v != "app" {
+			t.Fatal(v, err)
+		}
+		if v, err := r.Origin("footer.html"); err != nil || v != "theme" {
+			t.Fatal(v, err)
+		}
+		if _, err := r.Origin("undefined.html"); err == nil {
+			t.Fatal("Error")
+		}
+	}
+	// 存在しないディレクトリが含まれる場合は、エラーとなる
+	conf.Directories = []string{"app", "undefined"}
+	if _, err := conf.New(); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func Test_CONFIG_RENDER_LOCALE(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":       &fstest.MapFile{Data: []byte(`{{import "header.html"}}:{{partial "body.html" .}}:{{hastemplate "footer.html"}}`)},
+		"index.ja-JP.html": &fstest.MapFile{Data: []byte(`ja-JP:{{import "header.html"}}`)},
+		"header.html":      &fstest.MapFile{Data: []byte(`header`)},
+		"header.en.html":   &fstest.MapFile{Data: []byte(`header.en`)},
+		"body.html":        &fstest.MapFile{Data: []byte(`body`)},
+		"body.en-US.html":  &fstest.MapFile{Data: []byte(`body.en-US`)},
+		"footer.fr.html":   &fstest.MapFile{Data: []byte(`footer.fr`)},
+	}
+	var conf = &Config{FS: fsys}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []struct{ locale, expect string }{
+			{"", "header:body:false"},
+			{"en-US", "header.en:body.en-US:false"},
+			{"en_GB", "header.en:body:false"},
+			{"fr", "header:body:true"},
+			{"ja-JP", "ja-JP:header"},
+			{"ja", "header:body:false"},
+		} {
+			buf, err := r.RenderLocale("index.html", v.locale, nil)
+			if err != nil || string(buf) != v.expect {
+				t.Fatal(v.locale, string(buf), err)
+			}
+		}
+		// 候補がいずれも存在しない場合は、エラーとなる
+		if _, err := r.RenderLocale("undefined.html", "ja", nil); err == nil {
+			t.Fatal("Error")
+		}
+	}
+}
+
+func Test_CONFIG_MESSAGES(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/index.html": &fstest.MapFile{Data: []byte(`{{t "home.title"}}|{{t "hello" "name" .}}|{{tn "apples" 1}}|{{tn "apples" 3}}|{{tn "apples" 0}}|{{t "undefined"}}`)},
+		"locales/en.json": &fstest.MapFile{Data: []byte(`{
+			"home": {"title": "Home"},
+			"hello": "Hello, {name}",
+			"apples": {"zero": "no apples", "one": "{count} apple", "other": "{count} apples"}
+		}`)},
+		"locales/ja.json": &fstest.MapFile{Data: []byte(`{"home": {"title": "ホーム"}, "apples": {"other": "りんご{count}個"}}`)},
+		"locales/de.json": &fstest.MapFile{Data: []byte(`{"hello": "Hallo, {name}", "home": {"title": "Startseite"}, "apples": {"one": "{count} Apfel", "other": "{count} Äpfel"}}`)},
+	}
+	var conf = &Config{
+		FS:        fsys,
+		Directory: "views",
+		Messages:  "locales",
+		Locale:    "en",
+	}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ロケール未指定の場合は、Locale のカタログを使用する
+		buf, err := r.Render("index.html", "Taro")
+		if err != nil || string(buf) != "Home|Hello, Taro|1 apple|3 apples|no apples|undefined" {
+			t.Fatal(string(buf), err)
+		}
+		// ロケールのカタログに存在しないメッセージは、Locale のカタログを使用する
+		buf, err = r.RenderLocale("index.html", "ja-JP", "Taro")
+		if err != nil || string(buf) != "ホーム|Hello, Taro|りんご1個|りんご3個|りんご0個|undefined" {
+			t.Fatal(string(buf), err)
+		}
+		buf, err = r.RenderLocale("index.html", "de", "Taro")
+		if err != nil || string(buf) != "Startseite|Hallo, Taro|1 Apfel|3 Äpfel|0 Äpfel|undefined" {
+			t.Fatal(string(buf), err)
+		}
+		buf, err = r.RenderString(`{{t "home.title"}}`, nil)
+		if err != nil || string(buf) != "Home" {
+			t.Fatal(string(buf), err)
+		}
+		// コピーしたレンダーも、Locale のカタログを使用する
+		buf, err = r.Copy().Render("index.html", "Taro")
+		if err != nil || string(buf) != "Home|Hello, Taro|1 apple|3 apples|no apples|undefined" {
+			t.Fatal(string(buf), err)
+		}
+		// メッセージ関数と同じ名前のヘルパは、登録できない
+		if err := r.AddHelper(template.FuncMap{"t": func() string { return "" }}); !errors.Is(err, core.ErrHelper) {
+			t.Fatal(err)
+		}
+	}
+	// カタログの形式が誤っている場合は、エラーとなる
+	fsys["locales/fr.json"] = &fstest.MapFile{Data: []byte(`{"home": 1}`)}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.RenderLocale("index.html", "fr", nil); err == nil {
+			t.Fatal("Error")
+		}
+	}
+	// 存在しないディレクトリを指定した場合は、エラーとなる
+	conf.Messages = "undefined"
+	if _, err := conf.New(); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func Test_CONFIG_RENDER_RESULT(t *testing.T) {
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"views/index.html":   &fstest.MapFile{Data: []byte(`{{import "header.html"}}{{partial "card.html" 1}}{{partial "card.html" 2}}`), ModTime: modtime},
+		"views/header.html":  &fstest.MapFile{Data: []byte(`<h1></h1>`)},
+		"views/card.html":    &fstest.MapFile{Data: []byte(`{{.}}`)},
+		"views/image.png":    &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}, ModTime: modtime},
+		"views/data.unknown": &fstest.MapFile{Data: []byte(`plain text`)},
+	}
+	var conf = &Config{FS: fsys, Directory: "views", Binary: true}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := r.RenderResult("index.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(res.Body) != "<h1></h1>12" || res.Name != "index.html" || res.Path != "views/index.html" ||
+			res.ContentType != "text/html; charset=utf-8" || res.IsBinary || !res.ModTime.Equal(modtime) || res.Size != 11 ||
+			strings.Join(res.Imports, ",") != "header.html,card.html" {
+			t.Fatal(cache, res)
+		}
+		res, err = r.RenderResult("image.png", nil)
+		if err != nil || !res.IsBinary || res.ContentType != "image/png" || res.Size != 6 || len(res.Imports) != 0 {
+			t.Fatal(res, err)
+		}
+		// 拡張子から判定できない場合は、内容から判定する
+		res, err = r.RenderResult("data.unknown", nil)
+		if err != nil || res.ContentType != "text/plain; charset=utf-8" {
+			t.Fatal(res, err)
+		}
+		if _, err := r.RenderResult("undefined.html", nil); err == nil {
+			t.Fatal("Error")
+		}
+	}
+}
+
+func Test_CONFIG_FRAGMENT_CACHE(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`{{cache "nav" 60 "nav.html" .}}|{{cache "nav" 60 "nav.html" .}}`)},
+		"nav.html":   &fstest.MapFile{Data: []byte(`<nav>{{.}}:{{count}}</nav>`)},
+	}
+	var conf = &Config{FS: fsys, Escape: []string{".html"}}
+	for _, cache := range []bool{true, false} {
+		conf.Cache = cache
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var count int
+		r.AddHelper(template.FuncMap{"count": func() int { count++; return count }})
+		// 同じキーの解析結果は、有効期限内は再利用する。html/template でも二重にエスケープしない
+		buf, err := r.Render("index.html", "a")
+		if err != nil || string(buf) != "<nav>a:1</nav>|<nav>a:1</nav>" {
+			t.Fatal(string(buf), err)
+		}
+		// キーの要素が異なる場合は、別の解析結果となる
+		buf, _ = r.Render("index.html", "b")
+		if string(buf) != "<nav>b:2</nav>|<nav>b:2</nav>" {
+			t.Fatal(string(buf))
+		}
+		// コピーしたレンダーオブジェクトと共有する
+		buf, _ = r.Copy().Render("index.html", "a")
+		if string(buf) != "<nav>a:1</nav>|<nav>a:1</nav>" {
+			t.Fatal(string(buf))
+		}
+		// 削除した場合は、再度解析する
+		r.Invalidate("nav", "a")
+		buf, _ = r.Render("index.html", "a")
+		if string(buf) != "<nav>a:3</nav>|<nav>a:3</nav>" {
+			t.Fatal(string(buf))
+		}
+		if _, err := r.RenderString(`{{cache "nav" "x" "nav.html"}}`, nil); err == nil {
+			t.Fatal("Error")
+		}
+	}
+}
+
+func Test_CONFIG_RENDER_ERROR_DETAIL(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("<main>\n{{partial \"part.html\" .}}\n</main>\n")},
+		"part.html":  &fstest.MapFile{Data: []byte("line1\nline2\n\t{{.Name.Value}}\nline4\nline5\nline6\n")},
+	}
+	broken := fstest.MapFS{
+		"broken.html": &fstest.MapFile{Data: []byte("line1\n{{if}}\n")},
+	}
+	for _, cache := range []bool{true, false} {
+		// パースエラーは、エラー発生箇所の前後の行を保持する
+		r, err := (&Config{FS: broken, Cache: cache}).New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Render("broken.html", nil)
+		if rerr, ok := err.(*core.RenderError); !ok || len(rerr.Excerpt) != 2 || rerr.Excerpt[1].Text != "{{if}}" {
+			t.Fatal(cache, err)
+		}
+
+		// partial 先のエラーは、partial 元から順に位置を保持し、元のファイル内容の前後の行を保持する
+		r, err = (&Config{FS: fsys, Cache: cache, Escape: []string{".html"}}).New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = r.Render("index.html", map[string]interface{}{"Name": 1})
+		rerr, ok := err.(*core.RenderError)
+		if !ok {
+			t.Fatal(cache, err)
+		}
+		if len(rerr.Stack) != 2 || rerr.Stack[0].Name != "index.html" || rerr.Stack[0].Line != 2 ||
+			rerr.Stack[1].Name != "part.html" || rerr.Stack[1].Line != 3 || rerr.Stack[1].Column != 8 {
+			t.Fatal(cache, rerr.Stack)
+		}
+		if rerr.Root != "<main>\n{{partial \"part.html\" .}}\n</main>\n" {
+			t.Fatal(cache, rerr.Root)
+		}
+		if len(rerr.Excerpt) != 5 || rerr.Excerpt[0].Number != 1 || rerr.Excerpt[4].Text != "line5" {
+			t.Fatal(cache, rerr.Excerpt)
+		}
+		detail := rerr.Detail()
+		if !strings.Contains(detail, "  in index.html:2:2\n  in part.html:3:8\n") ||
+			!strings.Contains(detail, "> 3 | \t{{.Name.Value}}\n    | \t       ^\n") {
+			t.Fatal(cache, detail)
+		}
+	}
+}
+
+func Test_CONFIG_RENDER_CONTEXT(t *testing.T) {
+	fsys := fstest.MapFS{
+		"loop.html":   &fstest.MapFile{Data: []byte(`{{range .}}{{if false}}x{{end}}{{end}}`)},
+		"loop.txt":    &fstest.MapFile{Data: []byte(`{{range .}}{{if false}}x{{end}}{{end}}`)},
+		"nested.html": &fstest.MapFile{Data: []byte("<script>var a = [\n{{range .}}{{.}},{{end}}];</script>")},
+	}
+	// 要素のサイズが 0 のため、メモリを使用せずに長時間ループする
+	var data = make([]struct{}, 1<<28)
+	for _, cache := range []bool{true, false} {
+		r, err := (&Config{FS: fsys, Cache: cache, Escape: []string{".html"}}).New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 何も出力しないテンプレートでも、range の繰り返しの間でタイムアウトを検知する
+		for _, name := range []string{"loop.html", "loop.txt"} {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			start := time.Now()
+			_, err := r.RenderContext(ctx, name, data)
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+				t.Fatal(name, time.Since(start), err)
+			}
+		}
+		// 確認処理は、何も出力しない
+		buf, err := r.Render("nested.html", []int{1, 2})
+		if err != nil || string(buf) != "<script>var a = [\n 1 , 2 ,];</script>" {
+			t.Fatal(string(buf), err)
+		}
+	}
+}
@@ -1,6 +1,15 @@
 package core
 
-import "text/template"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
 
 // Render : Renderインタフェース
 type Render interface {
@@ -21,8 +30,64 @@ type Render interface {
 
 	// 指定したレンダー名で、テンプレート解析を実施する
 	Render(string, interface{}) ([]byte, error)
+
+	// 指定したレンダー名で、テンプレート解析結果を io.Writer へ書き込む
+	RenderTo(io.Writer, string, interface{}) error
+
+	// コンテキストを指定して、テンプレート解析を実施する。キャンセル、タイムアウト時は ContextError を返却する
+	RenderContext(context.Context, string, interface{}) ([]byte, error)
+
+	// 指定したレンダー名で、テンプレート解析を実施し、解析結果とファイルの情報を返却する
+	RenderResult(string, interface{}) (*Result, error)
+
+	// 指定したレイアウトの中に、テンプレート解析結果を埋め込む
+	RenderWithLayout(string, string, interface{}) ([]byte, error)
+
+	// ロケールを考慮して、テンプレート解析を実施する
+	RenderLocale(string, string, interface{}) ([]byte, error)
+
+	// コンテキストを指定して、ロケールを考慮したテンプレート解析を実施する
+	RenderLocaleContext(context.Context, string, string, interface{}) ([]byte, error)
+
+	// レンダーファイルを再読み込みする
+	Reload() error
+
+	// 使用中のレンダーファイルのバージョンを取得する
+	Version() string
+
+	// 指定した名前のファイルを読み込んだディレクトリを取得する
+	Origin(string) (string, error)
+
+	// 指定した名前のファイルの情報を取得する
+	Stat(string) (*FileInfo, error)
+
+	// 指定した名前のファイルの、指定したエンコーディングの圧縮データを取得する
+	Encoded(string, string) ([]byte, error)
+
+	// 指定した名前のファイルが、import, partial, layout, cache で読み込む部品のファイルか確認する
+	IsPartial(string) bool
+
+	// cache 関数で保持している、指定したキーの解析結果を削除する
+	Invalidate(string, ...interface{})
+
+	// ファイルの監視を停止する
+	Close() error
 }
 
+// errors.Is で、エラーの種類を判定するためのエラー
+var (
+	ErrNotFound       = errors.New("render: template not found")        // 存在しない、または対象外のテンプレートファイル
+	ErrRead           = errors.New("render: cannot read file")          // パーミッション等の理由で読み込めないファイル
+	ErrTooLarge       = errors.New("render: file size exceeds limit")   // MaxSize, SumMaxSize を超過したファイル
+	ErrBinaryDisabled = errors.New("render: binary files are disabled") // Binary = false の場合のバイナリファイル
+	ErrNotEncoded     = errors.New("render: encoding not available")    // 圧縮データが存在しないファイル
+	ErrParse          = errors.New("render: parse error")               // テンプレートのパースエラー
+	ErrExec           = errors.New("render: execute error")             // テンプレートの実行エラー
+	ErrCyclicLayout   = errors.New("render: cyclic layout")             // 循環しているレイアウト
+	ErrCatalog        = errors.New("render: invalid message catalog")   // 形式に誤りがあるメッセージカタログ
+	ErrHelper         = errors.New("render: invalid helper")            // 登録できないヘルパ
+)
+
 // HelperInvalid : ヘルパ登録時のエラー型
 type HelperInvalid struct {
 	Message string // エラーメッセージ本文
@@ -34,25 +99,158 @@ func (err *HelperInvalid) Error() string {
 	return err.Message
 }
 
+// Unwrap : ErrHelper を返却する
+func (err *HelperInvalid) Unwrap() error {
+	return ErrHelper
+}
+
 // RenderError : Parse, Execute でエラーが起こった場合のエラー型
 type RenderError struct {
-	Message  string // エラーメッセージ
-	Line     int    // エラー発生行番号
-	Column   int    // エラーカラム番号
-	Basename string // エラー元のレンダーファイル名
-	Root     string // エラー元のレンダーファイル本文
-	Target   string // 対象となるレンダーファイル名
+	Message  string       // エラーメッセージ
+	Line     int          // エラー発生行番号
+	Column   int          // エラーカラム番号
+	Basename string       // エラー元のレンダーファイル名
+	Root     string       // エラー元のレンダーファイル本文
+	Target   string       // 対象となるレンダーファイル名
+	Stack    []Frame      // エラー発生箇所までに読み込んだテンプレート。外側から順に格納する
+	Excerpt  []SourceLine // 最も内側のエラー発生箇所の前後の行
+	Category error        // ErrParse, または ErrExec
+	Err      error        // text/template, html/template が返却した元のエラー
 }
 
 func (err *RenderError) Error() string {
 	return err.Message
 }
 
-// TemplateError : 存在しないテンプレートファイルを指定した場合のエラー
+// Unwrap : エラーの種類と、元のエラーを返却する
+func (err *RenderError) Unwrap() []error {
+	return unwrap(err.Category, err.Err)
+}
+
+// Detail : エラーメッセージ、テンプレートの読み込み順、エラー発生箇所の前後の行を、端末やログ向けに整形して返却する
+// エラーカラムが判明している場合は、該当箇所に "^" を表示する
+func (err *RenderError) Detail() string {
+	var b strings.Builder
+	b.WriteString(err.Message)
+	b.WriteString("\n")
+	for _, frame := range err.Stack {
+		fmt.Fprintf(&b, "  in %s\n", frame)
+	}
+	if len(err.Excerpt) == 0 {
+		return b.String()
+	}
+
+	// 行番号の桁数を揃える
+	var width = len(strconv.Itoa(err.Excerpt[len(err.Excerpt)-1].Number))
+	var line, column = err.Line, err.Column
+	if n := len(err.Stack); n > 0 {
+		line, column = err.Stack[n-1].Line, err.Stack[n-1].Column
+	}
+	for _, src := range err.Excerpt {
+		mark := " "
+		if src.Number == line {
+			mark = ">"
+		}
+		fmt.Fprintf(&b, "%s %*d | %s\n", mark, width, src.Number, src.Text)
+		if src.Number != line || column <= 0 || column > len(src.Text) {
+			continue
+		}
+		// タブはそのまま出力し、カラム位置を揃える
+		var pad []rune
+		for _, c := range src.Text[:column] {
+			if c == '\t' {
+				pad = append(pad, '\t')
+			} else {
+				pad = append(pad, ' ')
+			}
+		}
+		fmt.Fprintf(&b, "  %*s | %s^\n", width, "", string(pad))
+	}
+	return b.String()
+}
+
+// Frame : エラー発生箇所までに読み込んだテンプレートの位置
+type Frame struct {
+	Name   string // レンダーファイル名
+	Line   int    // 行番号
+	Column int    // カラム番号(バイト単位。不明な場合は 0)
+}
+
+func (f Frame) String() string {
+	if f.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d", f.Name, f.Line, f.Column)
+	}
+	return fmt.Sprintf("%s:%d", f.Name, f.Line)
+}
+
+// SourceLine : レンダーファイルの1行
+type SourceLine struct {
+	Number int    // 行番号
+	Text   string // 行の内容
+}
+
+// FileInfo : レンダーファイルの情報
+type FileInfo struct {
+	Name     string    // レンダー名
+	Size     int64     // ファイルサイズ
+	ModTime  time.Time // ファイルの更新日時
+	ETag     string    // キャッシュありの場合は内容から、キャッシュなしの場合は更新日時とサイズから求めた ETag
+	IsBinary bool      // バイナリファイルの場合は true
+}
+
+// Result : 解析結果と、解析したファイルの情報
+type Result struct {
+	Body        []byte    // 解析結果。バイナリファイルの場合はファイルの内容
+	Name        string    // レンダー名
+	Path        string    // 読み込み元ディレクトリを含むファイルのパス
+	ContentType string    // 拡張子、または内容から判定した Content-Type
+	IsBinary    bool      // バイナリファイルの場合は true
+	ModTime     time.Time // ファイルの更新日時
+	Size        int64     // 解析結果のサイズ
+	Imports     []string  // 解析中に import, partial で読み込んだテンプレート名
+}
+
+// TemplateError : 存在しないテンプレートファイルを指定した場合等、テンプレートファイルを使用できない場合のエラー
 type TemplateError struct {
-	Message string
+	Message  string
+	Name     string // 対象となるファイル名
+	Category error  // ErrNotFound, ErrRead, ErrTooLarge 等のエラーの種類
+	Limit    int64  // ErrTooLarge の場合、超過した MaxSize, または SumMaxSize の設定値
+	Size     int64  // ErrTooLarge の場合、ファイルサイズ(SumMaxSize の場合は合計値)
+	Err      error  // ファイル読み込み時のエラー等、元のエラー
 }
 
 func (err *TemplateError) Error() string {
 	return err.Message
 }
+
+// Unwrap : エラーの種類と、元のエラーを返却する
+func (err *TemplateError) Unwrap() []error {
+	return unwrap(err.Category, err.Err)
+}
+
+// nil 以外のエラーを返却する
+func unwrap(errs ...error) []error {
+	var result []error
+	for _, err := range errs {
+		if err != nil {
+			result = append(result, err)
+		}
+	}
+	return result
+}
+
+// ContextError : コンテキストのキャンセル、タイムアウトにより、レンダーを中断した場合のエラー
+type ContextError struct {
+	Message string
+	Err     error // context.Canceled, または context.DeadlineExceeded
+}
+
+func (err *ContextError) Error() string {
+	return err.Message
+}
+
+// Unwrap : 元となるコンテキストのエラーを返却する
+func (err *ContextError) Unwrap() error {
+	return err.Err
+}
@@ -0,0 +1,150 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ochipin/render/core"
+)
+
+// ErrorPage : 開発時向けに、Render, RenderString 等が返却したエラーの内容を HTML で w へ書き込む
+// レンダーファイル名、行番号、カラム番号、エラー発生箇所の前後の行、テンプレートの読み込み順、
+// テンプレートへ渡したデータのキーと型を出力する。データの値は出力しない
+// レンダーファイルの内容を出力するため、本番環境では使用しないこと
+func ErrorPage(w io.Writer, err error, data interface{}) error {
+	var page = errorPage{Message: err.Error(), Type: fmt.Sprintf("%T", err), Data: dataKeys(data)}
+	var rerr *core.RenderError
+	if errors.As(err, &rerr) {
+		page.Render = rerr
+		// エラー発生箇所は、最も内側のテンプレートの位置とする
+		page.Name, page.Line, page.Column = rerr.Basename, rerr.Line, rerr.Column
+		if n := len(rerr.Stack); n > 0 {
+			page.Name, page.Line, page.Column = rerr.Stack[n-1].Name, rerr.Stack[n-1].Line, rerr.Stack[n-1].Column
+		}
+	}
+	return errorTemplate.Execute(w, page)
+}
+
+// エラーページへ渡すデータ
+type errorPage struct {
+	Message string
+	Type    string
+	Render  *core.RenderError
+	Name    string
+	Line    int
+	Column  int
+	Data    []dataKey
+}
+
+// テンプレートへ渡したデータのキーと型
+type dataKey struct {
+	Name string
+	Type string
+}
+
+// データの最上位のキーと型を取得する。map の場合はキー、構造体の場合は公開フィールドを対象とする
+func dataKeys(data interface{}) []dataKey {
+	var result []dataKey
+	v := reflect.ValueOf(data)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			result = append(result, dataKey{Name: fmt.Sprint(key.Interface()), Type: valueType(v.MapIndex(key))})
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if field := v.Type().Field(i); field.PkgPath == "" {
+				result = append(result, dataKey{Name: field.Name, Type: valueType(v.Field(i))})
+			}
+		}
+	default:
+		if v.IsValid() {
+			result = append(result, dataKey{Name: ".", Type: v.Type().String()})
+		}
+	}
+	return result
+}
+
+// 値の型名を取得する。interface{} の場合は、格納されている値の型名とする
+func valueType(v reflect.Value) string {
+	if v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() == reflect.Interface && v.IsNil()) {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+var errorTemplate = template.Must(template.New("error").Funcs(template.FuncMap{
+	// エラーカラムの位置に "^" を表示するための空白を作成する。タブはそのまま出力する
+	"caret": func(text string, column int) string {
+		if column <= 0 || column > len(text) {
+			return ""
+		}
+		return strings.Map(func(r rune) rune {
+			if r == '\t' {
+				return r
+			}
+			return ' '
+		}, text[:column]) + "^"
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="UTF-8">
+<title>{{with .Name}}{{.}}{{else}}Error{{end}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.2em; color: #b00; }
+pre { background: #f6f6f6; padding: 1em; overflow: auto; }
+.line { display: block; }
+.error { background: #fdd; }
+.caret { display: block; color: #b00; }
+th, td { text-align: left; padding: 0 1em 0 0; }
+</style>
+</head>
+<body>
+<h1>{{.Type}}</h1>
+<pre>{{.Message}}</pre>
+{{- with .Render}}
+<h2>{{$.Name}}{{if $.Line}}:{{$.Line}}{{if $.Column}}:{{$.Column}}{{end}}{{end}}</h2>
+{{- if .Excerpt}}
+<pre>
+{{- range .Excerpt}}
+{{- if eq .Number $.Line}}<span class="line error">{{printf "%4d" .Number}} | {{.Text}}</span>{{with caret .Text $.Column}}<span class="caret">     | {{.}}</span>{{end}}
+{{- else}}<span class="line">{{printf "%4d" .Number}} | {{.Text}}</span>{{end}}
+{{- end}}</pre>
+{{- end}}
+{{- if .Stack}}
+<h2>Stack</h2>
+<ol>
+{{- range .Stack}}
+<li>{{.}}</li>
+{{- end}}
+</ol>
+{{- end}}
+{{- end}}
+{{- if .Data}}
+<h2>Data</h2>
+<table>
+{{- range .Data}}
+<tr><th>{{.Name}}</th><td>{{.Type}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`))
@@ -0,0 +1,88 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_ERROR_PAGE(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte("<main>\n{{partial \"part.html\" .}}\n</main>\n")},
+		"part.html":  &fstest.MapFile{Data: []byte("line1\n<b>{{.Name.Value}}</b>\n")},
+	}
+	for _, cache := range []bool{true, false} {
+		r, err := (&Config{FS: fsys, Cache: cache}).New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data := map[string]interface{}{"Name": 1, "Password": "secret"}
+		_, err = r.Render("index.html", data)
+		if err == nil {
+			t.Fatal("Error")
+		}
+		var buf bytes.Buffer
+		if err := ErrorPage(&buf, err, data); err != nil {
+			t.Fatal(err)
+		}
+		page := buf.String()
+		// エラー発生箇所、読み込み順、データのキーと型を出力し、ソースはエスケープする。データの値は出力しない
+		for _, s := range []string{
+			"<h2>part.html:2:10</h2>",
+			`<span class="line error">   2 | &lt;b&gt;{{.Name.Value}}&lt;/b&gt;</span>`,
+			`<span class="caret">     |           ^</span>`,
+			"<li>index.html:2:2</li>",
+			"<li>part.html:2:10</li>",
+			"<tr><th>Name</th><td>int</td></tr>",
+			"<tr><th>Password</th><td>string</td></tr>",
+		} {
+			if !strings.Contains(page, s) {
+				t.Fatal(cache, s, page)
+			}
+		}
+		if strings.Contains(page, "secret") {
+			t.Fatal(page)
+		}
+	}
+
+	// RenderError 以外のエラーは、エラーメッセージのみ出力する
+	var buf bytes.Buffer
+	ErrorPage(&buf, errors.New("<fail>"), struct {
+		Title   string
+		private int
+	}{})
+	if !strings.Contains(buf.String(), "&lt;fail&gt;") || !strings.Contains(buf.String(), "<tr><th>Title</th><td>string</td></tr>") ||
+		strings.Contains(buf.String(), "private") {
+		t.Fatal(buf.String())
+	}
+}
+
+func Test_HANDLER_DEBUG(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`{{.Name.Value}}`)},
+	}
+	r, err := (&Config{FS: fsys}).New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(r)
+	h.Data = func(*http.Request) (interface{}, error) { return map[string]interface{}{"Name": 1}, nil }
+
+	// 既定では、エラーの詳細を出力しない
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != 500 || strings.Contains(w.Body.String(), "Name.Value") {
+		t.Fatal(w.Code, w.Body.String())
+	}
+	h.Debug = true
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "{{.Name.Value}}") ||
+		w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Fatal(w.Code, w.Body.String())
+	}
+}
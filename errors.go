@@ -0,0 +1,17 @@
+package render
+
+import "github.com/ochipin/render/core"
+
+// errors.Is で、エラーの種類を判定するためのエラー
+var (
+	ErrNotFound       = core.ErrNotFound       // 存在しない、または対象外のテンプレートファイル
+	ErrRead           = core.ErrRead           // パーミッション等の理由で読み込めないファイル
+	ErrTooLarge       = core.ErrTooLarge       // MaxSize, SumMaxSize を超過したファイル
+	ErrBinaryDisabled = core.ErrBinaryDisabled // Binary = false の場合のバイナリファイル
+	ErrNotEncoded     = core.ErrNotEncoded     // 圧縮データが存在しないファイル
+	ErrParse          = core.ErrParse          // テンプレートのパースエラー
+	ErrExec           = core.ErrExec           // テンプレートの実行エラー
+	ErrCyclicLayout   = core.ErrCyclicLayout   // 循環しているレイアウト
+	ErrCatalog        = core.ErrCatalog        // 形式に誤りがあるメッセージカタログ
+	ErrHelper         = core.ErrHelper         // 登録できないヘルパ
+)
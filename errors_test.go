@@ -0,0 +1,104 @@
+package render
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"text/template"
+
+	"github.com/ochipin/render/core"
+)
+
+func Test_ERRORS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":  &fstest.MapFile{Data: []byte(`{{.Name.Value}}`)},
+		"import.html": &fstest.MapFile{Data: []byte(`{{template "undefined.html"}}`)},
+		"layout.html": &fstest.MapFile{Data: []byte(`{{layout "layout.html"}}`)},
+		"large.html":  &fstest.MapFile{Data: []byte(`0123456789012345678901234567890123456789`)},
+		"image.png":   &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+	}
+	// キャッシュありの場合、MaxSize を超過したファイルは New で ErrTooLarge となる
+	_, err := (&Config{FS: fsys, Cache: true, MaxSize: 32}).New()
+	var terr *core.TemplateError
+	if !errors.Is(err, ErrTooLarge) || !errors.As(err, &terr) || terr.Name != "large.html" {
+		t.Fatal(err)
+	}
+
+	for _, cache := range []bool{true, false} {
+		conf := &Config{FS: fsys, Cache: cache}
+		if !cache {
+			conf.MaxSize = 32
+		}
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 実行エラーは ErrExec となり、元のエラーを取得できる
+		_, err = r.Render("index.html", map[string]interface{}{"Name": 1})
+		var exec template.ExecError
+		if !errors.Is(err, ErrExec) || errors.Is(err, ErrParse) || !errors.As(err, &exec) {
+			t.Fatal(cache, err)
+		}
+		// パースエラーは ErrParse となる
+		_, err = r.RenderString(`{{if}}`, nil)
+		if !errors.Is(err, ErrParse) {
+			t.Fatal(cache, err)
+		}
+		// 存在しないテンプレートファイルは ErrNotFound となる
+		_, err = r.Render("undefined.html", nil)
+		if !errors.Is(err, ErrNotFound) || !errors.As(err, &terr) || terr.Name != "undefined.html" {
+			t.Fatal(cache, err)
+		}
+		// 解析中に読み込むテンプレートが存在しない場合は、ErrExec となり Target に格納する
+		_, err = r.Render("import.html", nil)
+		var rerr *core.RenderError
+		if !errors.Is(err, ErrExec) || errors.Is(err, ErrNotFound) || !errors.As(err, &rerr) || rerr.Target != "undefined.html" {
+			t.Fatal(cache, err)
+		}
+		if _, err = r.Render("layout.html", nil); !errors.Is(err, ErrCyclicLayout) {
+			t.Fatal(cache, err)
+		}
+		if _, err = r.Encoded("index.html", "gzip"); !errors.Is(err, ErrNotEncoded) {
+			t.Fatal(cache, err)
+		}
+		if err = r.AddHelper(template.FuncMap{"nilfunc": nil}); !errors.Is(err, ErrHelper) {
+			t.Fatal(cache, err)
+		}
+		if err = r.Helper(1); !errors.Is(err, ErrHelper) {
+			t.Fatal(cache, err)
+		}
+	}
+
+	// import, partial で読み込むテンプレートが存在しない場合も、キャッシュの有無、エスケープの有無に関わらず ErrExec となる
+	pages := fstest.MapFS{
+		"import.html":  &fstest.MapFile{Data: []byte(`{{import "undefined.html"}}`)},
+		"partial.html": &fstest.MapFile{Data: []byte(`{{partial "undefined.html" .}}`)},
+	}
+	for _, cache := range []bool{true, false} {
+		for _, escape := range [][]string{nil, {".html"}} {
+			r, err := (&Config{FS: pages, Cache: cache, Escape: escape}).New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name := range pages {
+				_, err := r.Render(name, nil)
+				if !errors.Is(err, ErrExec) || errors.Is(err, ErrNotFound) || StatusCode(err) != 500 {
+					t.Fatal(cache, escape, name, err)
+				}
+			}
+		}
+	}
+
+	// キャッシュなしの場合は、バイナリファイル、サイズ超過、読み込み失敗を区別する
+	r, _ := (&Config{FS: fsys, MaxSize: 32}).New()
+	if _, err := r.Render("image.png", nil); !errors.Is(err, ErrBinaryDisabled) {
+		t.Fatal(err)
+	}
+	if _, err := r.Render("large.html", nil); !errors.Is(err, ErrTooLarge) {
+		t.Fatal(err)
+	}
+	if _, err := r.Render("missing.html", nil); !errors.Is(err, ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+}
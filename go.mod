@@ -1 +1,3 @@
 module github.com/ochipin/render
+
+go 1.21
@@ -0,0 +1,36 @@
+package render
+
+import (
+	"github.com/ochipin/render/internal/common"
+)
+
+// Graph : テンプレートの依存関係を表す有向グラフ
+type Graph = common.Graph
+
+// GraphEdge : テンプレート間の参照
+type GraphEdge = common.Edge
+
+// Graph : Config の設定に従って全てのレンダーファイルを読み込み、テンプレートの依存関係を取得する
+// 読み込むファイルは、Cache = true の場合と同じく、Targets, Binary, MaxSize, SumMaxSize に従う
+// パースエラーが発生した場合は、エラーを返却する
+func (config *Config) Graph() (*Graph, error) {
+	_, _, filelist, err := config.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return config.graph(filelist)
+}
+
+// 読み込んだファイルのうち、テンプレートファイルの依存関係を取得する
+func (config *Config) graph(filelist []*common.File) (*Graph, error) {
+	var graph = common.NewGraph()
+	for _, file := range filelist {
+		if file.IsBinary {
+			continue
+		}
+		if err := graph.Add(file.FileName, string(file.FileData), common.DelimsOf(file.FileName, config.Delims, config.ExtDelims)); err != nil {
+			return nil, err
+		}
+	}
+	return graph, nil
+}
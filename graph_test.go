@@ -0,0 +1,84 @@
+package render
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_CONFIG_GRAPH(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":  &fstest.MapFile{Data: []byte(`{{layout "layout.html"}}{{define "body"}}{{import "header.html"}}{{template "nav" .}}{{end}}`)},
+		"about.html":  &fstest.MapFile{Data: []byte(`{{import "%s.html" "header"}}{{import "%s.html" .Name}}{{if hastemplate "side.html"}}{{partial "side.html" .}}{{end}}`)},
+		"layout.html": &fstest.MapFile{Data: []byte(`<body>{{block "body" .}}{{end}}{{cache "k" 60 "footer.html" .}}</body>`)},
+		"header.html": &fstest.MapFile{Data: []byte(`{{define "nav"}}<nav></nav>{{end}}<h1></h1>`)},
+		"a.html":      &fstest.MapFile{Data: []byte(`{{import "b.html"}}`)},
+		"b.html":      &fstest.MapFile{Data: []byte(`{{import "a.html"}}`)},
+		"tree.html":   &fstest.MapFile{Data: []byte(`{{define "node"}}{{range .}}{{template "node" .Children}}{{end}}{{end}}`)},
+		"image.png":   &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+	}
+	g, err := (&Config{FS: fsys, Binary: true}).Graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(g.Nodes, ",") != "a.html,about.html,b.html,body,header.html,index.html,layout.html,nav,node,tree.html" {
+		t.Fatal(g.Nodes)
+	}
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.From+">"+e.To+":"+e.Kind)
+	}
+	if strings.Join(edges, ",") != "a.html>b.html:import,about.html>header.html:import,about.html>side.html:hastemplate,about.html>side.html:partial,"+
+		"b.html>a.html:import,body>header.html:import,body>nav:template,index.html>layout.html:layout,"+
+		"layout.html>body:block,layout.html>footer.html:cache,node>node:template" {
+		t.Fatal(edges)
+	}
+	// 書式の引数が定数ではない import は、解決できない参照となる
+	if len(g.Unresolved) != 1 || g.Unresolved[0].From != "about.html" || g.Unresolved[0].To != "%s.html" || g.Unresolved[0].Column != 31 {
+		t.Fatal(g.Unresolved)
+	}
+
+	// 逆引き
+	if strings.Join(g.UsedBy("header.html"), ",") != "about.html,body" || strings.Join(g.Uses("about.html"), ",") != "header.html,side.html" {
+		t.Fatal(g.UsedBy("header.html"), g.Uses("about.html"))
+	}
+	// ファイル内で定義したテンプレートを参照しているテンプレートも、影響を受ける
+	if strings.Join(g.Dependents("header.html"), ",") != "about.html,body,index.html,layout.html" || strings.Join(g.Files("body"), ",") != "index.html,layout.html" {
+		t.Fatal(g.Dependents("header.html"))
+	}
+
+	// 循環参照
+	cycles := g.Cycles()
+	if len(cycles) != 2 || strings.Join(cycles[0], ",") != "a.html,b.html" || strings.Join(cycles[1], ",") != "node" {
+		t.Fatal(cycles)
+	}
+
+	dot := g.DOT()
+	if !strings.HasPrefix(dot, "digraph templates {\n") || !strings.Contains(dot, `  "body" -> "nav" [label="template"];`) ||
+		!strings.Contains(dot, `  "footer.html" [style=dashed];`) {
+		t.Fatal(dot)
+	}
+	buf, err := json.Marshal(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Nodes []struct {
+			Name  string
+			Files []string
+		}
+		Edges  []GraphEdge
+		Cycles [][]string
+	}
+	if err := json.Unmarshal(buf, &v); err != nil || len(v.Nodes) != 10 || v.Nodes[7].Files[0] != "header.html" || len(v.Edges) != 11 || len(v.Cycles) != 2 {
+		t.Fatal(string(buf), err)
+	}
+
+	// パースエラーの場合は、エラーを返却する
+	fsys["broken.html"] = &fstest.MapFile{Data: []byte(`{{if}}`)}
+	if _, err := (&Config{FS: fsys}).Graph(); !errors.Is(err, ErrParse) {
+		t.Fatal(err)
+	}
+}
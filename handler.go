@@ -0,0 +1,186 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ochipin/render/internal/common"
+)
+
+// Handler : Render を元に、URL のパスに対応するテンプレートファイル、バイナリファイルを配信する http.Handler
+type Handler struct {
+	Render Render                                               // 配信に使用するレンダーオブジェクト
+	Index  string                                               // パスが "/" で終わる場合に付与するファイル名(空文字 = index.html)
+	Filter func(string) bool                                    // 配信するレンダー名か確認する関数(nil = Render.IsPartial が true となる部品のファイルは配信しない)
+	Data   func(*http.Request) (interface{}, error)             // テンプレートへ渡すデータを返却する関数(nil = データなし)
+	Locale func(*http.Request) string                           // ロケールを返却する関数(nil = ロケールを考慮しない)
+	Status func(error) int                                      // エラーに対応するステータスコードを返却する関数(nil = StatusCode)
+	Error  func(http.ResponseWriter, *http.Request, int, error) // エラーページを出力する関数(nil = ステータスコードの文字列を出力)
+	Debug  bool                                                 // true の場合、Error が nil であれば ErrorPage でエラーの詳細を出力する。本番環境では使用しないこと
+}
+
+// NewHandler : 指定したレンダーオブジェクトで配信する Handler を生成する
+func NewHandler(r Render) *Handler {
+	return &Handler{Render: r}
+}
+
+// ServeHTTP : GET, HEAD リクエストに対して、パスに対応するファイルの解析結果を返却する
+// ETag による条件付きリクエスト、Range リクエストに対応する
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		h.error(w, req, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)), nil)
+		return
+	}
+
+	// layout, import, partial 等で読み込む部品のファイルは、単体のページとして配信しない
+	name := h.Name(req)
+	if !h.allow(name) {
+		err := common.NotDefined(name, ErrNotFound)
+		h.error(w, req, h.status(err), err, nil)
+		return
+	}
+
+	// 圧縮データがある場合は、Accept-Encoding に応じて圧縮データを返却する
+	// 圧縮データの返却にはテンプレートへ渡すデータを使用しないため、Data の呼び出しより先に確認する
+	if h.Locale == nil {
+		if encoded, err := h.Render.Encoded(name, "gzip"); err == nil {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if acceptEncoding(req.Header.Get("Accept-Encoding"), "gzip") {
+				h.serve(w, req, name, "gzip", encoded)
+				return
+			}
+		}
+	}
+
+	// テンプレートへ渡すデータを取得する
+	var data interface{}
+	if h.Data != nil {
+		var err error
+		if data, err = h.Data(req); err != nil {
+			h.error(w, req, h.status(err), err, nil)
+			return
+		}
+	}
+
+	// パスに対応するファイルを解析する
+	var buf []byte
+	var err error
+	if h.Locale != nil {
+		buf, err = h.Render.RenderLocaleContext(req.Context(), name, h.Locale(req), data)
+	} else {
+		buf, err = h.Render.RenderContext(req.Context(), name, data)
+	}
+	if err != nil {
+		h.error(w, req, h.status(err), err, data)
+		return
+	}
+	h.serve(w, req, name, "", buf)
+}
+
+// 解析結果、または圧縮データを返却する。encoding には、圧縮データの場合にエンコーディング名を指定する
+// ETag は返却するデータから作成し、圧縮データの場合はエンコーディング名を付与する。バイナリファイルの場合は、更新日時も返却する
+// If-None-Match, If-Modified-Since, Range, HEAD リクエストは http.ServeContent で処理する
+func (h *Handler) serve(w http.ResponseWriter, req *http.Request, name, encoding string, body []byte) {
+	var etag = common.ETag(body)
+	var modtime time.Time
+	if info, err := h.Render.Stat(name); err == nil && info.IsBinary {
+		// バイナリファイルの ETag は、読み込み時のファイルの内容から求めた ETag と同じとなる
+		if encoding == "" {
+			etag = info.ETag
+		}
+		modtime = info.ModTime
+	}
+	// Content-Type は拡張子から判定し、判定できない場合は内容から判定する
+	if encoding != "" {
+		etag = strings.TrimSuffix(etag, `"`) + "-" + encoding + `"`
+		w.Header().Set("Content-Type", common.ContentType(name, nil))
+		w.Header().Set("Content-Encoding", encoding)
+	} else {
+		w.Header().Set("Content-Type", common.ContentType(name, body))
+	}
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, req, name, modtime, bytes.NewReader(body))
+}
+
+// Accept-Encoding で、指定したエンコーディングが許可されているか確認する
+func acceptEncoding(header, encoding string) bool {
+	for _, v := range strings.Split(header, ",") {
+		params := strings.Split(v, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+		// q=0 の場合は、許可しない
+		for _, param := range params[1:] {
+			param = strings.ReplaceAll(param, " ", "")
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") && err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Name : リクエストのパスから、レンダー名を取得する
+func (h *Handler) Name(req *http.Request) string {
+	name := path.Clean("/" + req.URL.Path)
+	// ディレクトリを指すパスの場合は、インデックスファイル名を付与する
+	if strings.HasSuffix(req.URL.Path, "/") {
+		index := h.Index
+		if index == "" {
+			index = "index.html"
+		}
+		name = path.Join(name, index)
+	}
+	return strings.TrimPrefix(name, "/")
+}
+
+// 配信するレンダー名か確認する
+func (h *Handler) allow(name string) bool {
+	if h.Filter != nil {
+		return h.Filter(name)
+	}
+	return !h.Render.IsPartial(name)
+}
+
+// エラーに対応するステータスコードを取得する
+func (h *Handler) status(err error) int {
+	if h.Status != nil {
+		return h.Status(err)
+	}
+	return StatusCode(err)
+}
+
+// エラーページを出力する
+func (h *Handler) error(w http.ResponseWriter, req *http.Request, code int, err error, data interface{}) {
+	if h.Error != nil {
+		h.Error(w, req, code, err)
+		return
+	}
+	// 開発時は、エラーの詳細を出力する
+	if h.Debug {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(code)
+		ErrorPage(w, err, data)
+		return
+	}
+	// エラーの詳細は、クライアントへ返却しない
+	http.Error(w, http.StatusText(code), code)
+}
+
+// StatusCode : エラーに対応するステータスコードを返却する
+// ErrNotFound, ErrBinaryDisabled, ErrNotEncoded は 404、それ以外のエラーは 500 となる
+func StatusCode(err error) int {
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrBinaryDisabled) || errors.Is(err, ErrNotEncoded) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,351 @@
+package render
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/ochipin/render/internal/common"
+)
+
+func Test_HANDLER(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":      &fstest.MapFile{Data: []byte(`<p>{{.}}</p>`)},
+		"index.ja.html":   &fstest.MapFile{Data: []byte(`<p>ja:{{.}}</p>`)},
+		"app/style.css":   &fstest.MapFile{Data: []byte(`p{}`)},
+		"app/errors.html": &fstest.MapFile{Data: []byte(`{{.Undefined}}`)},
+		"image.png":       &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+	}
+	for _, cache := range []bool{true, false} {
+		conf := &Config{FS: fsys, Cache: cache, Binary: true}
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(r)
+		h.Data = func(req *http.Request) (interface{}, error) {
+			if req.URL.Query().Get("fail") != "" {
+				return nil, errors.New("fail")
+			}
+			return "Hello", nil
+		}
+		for _, v := range []struct {
+			method, url string
+			code        int
+			ctype, body string
+		}{
+			{"GET", "/", 200, "text/html; charset=utf-8", "<p>Hello</p>"},
+			{"GET", "/index.html", 200, "text/html; charset=utf-8", "<p>Hello</p>"},
+			{"HEAD", "/", 200, "text/html; charset=utf-8", ""},
+			{"GET", "/app/style.css", 200, "text/css; charset=utf-8", "p{}"},
+			{"GET", "/image.png", 200, "image/png", "\x89PNG\x00\x01"},
+			{"GET", "/undefined.html", 404, "text/plain; charset=utf-8", "Not Found\n"},
+			{"GET", "/../index.html", 200, "text/html; charset=utf-8", "<p>Hello</p>"},
+			{"GET", "/app/errors.html", 500, "text/plain; charset=utf-8", "Internal Server Error\n"},
+			{"GET", "/?fail=1", 500, "text/plain; charset=utf-8", "Internal Server Error\n"},
+			{"POST", "/", 405, "text/plain; charset=utf-8", "Method Not Allowed\n"},
+		} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(v.method, v.url, nil))
+			if w.Code != v.code || w.Header().Get("Content-Type") != v.ctype || w.Body.String() != v.body {
+				t.Fatal(cache, v.method, v.url, w.Code, w.Header().Get("Content-Type"), w.Body.String())
+			}
+		}
+		// HEAD リクエストは、Content-Length のみ返却する
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("HEAD", "/", nil))
+		if w.Header().Get("Content-Length") != "12" {
+			t.Fatal(w.Header())
+		}
+
+		// ロケール、エラーとステータスの対応、エラーページを変更する
+		h.Locale = func(req *http.Request) string { return req.Header.Get("Accept-Language") }
+		h.Status = func(err error) int { return http.StatusTeapot }
+		h.Error = func(w http.ResponseWriter, req *http.Request, code int, err error) {
+			w.WriteHeader(code)
+			w.Write([]byte("custom"))
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Language", "ja")
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Body.String() != "<p>ja:Hello</p>" {
+			t.Fatal(w.Body.String())
+		}
+		// ロケールを考慮する場合も、リクエストのコンテキストでレンダーする
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var rerr error
+		h.Status = func(err error) int { rerr = err; return http.StatusTeapot }
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req.WithContext(ctx))
+		if w.Code != http.StatusTeapot || !errors.Is(rerr, context.Canceled) {
+			t.Fatal(w.Code, rerr)
+		}
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/undefined.html", nil))
+		if w.Code != http.StatusTeapot || w.Body.String() != "custom" {
+			t.Fatal(w.Code, w.Body.String())
+		}
+	}
+}
+
+func Test_HANDLER_CONDITIONAL(t *testing.T) {
+	modtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html": &fstest.MapFile{Data: []byte(`<p>{{.}}</p>`), ModTime: modtime},
+		"image.png":  &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01, 0x02, 0x03}, ModTime: modtime},
+	}
+	request := func(h http.Handler, url string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	for _, cache := range []bool{true, false} {
+		conf := &Config{FS: fsys, Cache: cache, Binary: true}
+		r, err := conf.New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// ファイルの情報を取得する
+		info, err := r.Stat("image.png")
+		if err != nil || !info.IsBinary || info.Size != 8 || !info.ModTime.Equal(modtime) || info.ETag == "" {
+			t.Fatal(info, err)
+		}
+		if info, err := r.Stat("index.html"); err != nil || info.IsBinary {
+			t.Fatal(info, err)
+		}
+		if _, err := r.Stat("undefined.html"); err == nil {
+			t.Fatal("Error")
+		}
+
+		h := NewHandler(r)
+		// バイナリファイルは、ファイルの ETag と更新日時を返却する
+		w := request(h, "/image.png")
+		if w.Code != 200 || w.Header().Get("ETag") != info.ETag || w.Header().Get("Last-Modified") != modtime.Format(http.TimeFormat) {
+			t.Fatal(w.Code, w.Header())
+		}
+		if w := request(h, "/image.png", "If-None-Match", info.ETag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatal(w.Code)
+		}
+		if w := request(h, "/image.png", "If-Modified-Since", modtime.Format(http.TimeFormat)); w.Code != http.StatusNotModified {
+			t.Fatal(w.Code)
+		}
+		// Range リクエスト
+		if w := request(h, "/image.png", "Range", "bytes=4-"); w.Code != http.StatusPartialContent || w.Body.String() != "\x00\x01\x02\x03" {
+			t.Fatal(w.Code, w.Body.String())
+		}
+		// テンプレートファイルは、解析結果の ETag を返却する
+		w = request(h, "/index.html")
+		etag := w.Header().Get("ETag")
+		if w.Code != 200 || etag == "" || w.Header().Get("Last-Modified") != "" {
+			t.Fatal(w.Code, w.Header())
+		}
+		if w := request(h, "/index.html", "If-None-Match", etag); w.Code != http.StatusNotModified {
+			t.Fatal(w.Code)
+		}
+		h.Data = func(*http.Request) (interface{}, error) { return "changed", nil }
+		if w := request(h, "/index.html", "If-None-Match", etag); w.Code != 200 || w.Body.String() != "<p>changed</p>" {
+			t.Fatal(w.Code, w.Body.String())
+		}
+	}
+}
+
+func Test_HANDLER_COMPRESS(t *testing.T) {
+	text := strings.Repeat("<p>static</p>", 100)
+	fsys := fstest.MapFS{
+		"static.html":  &fstest.MapFile{Data: []byte(text)},
+		"dynamic.html": &fstest.MapFile{Data: []byte(text + `{{.}}`)},
+		"style.css":    &fstest.MapFile{Data: []byte(strings.Repeat("p{}", 100))},
+		"image.png":    &fstest.MapFile{Data: append([]byte{0x89, 'P', 'N', 'G', 0x00}, make([]byte, 100)...)},
+	}
+	conf := &Config{FS: fsys, Cache: true, Binary: true, Compress: true}
+	r, err := conf.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// アクションを含まないテンプレートファイル、圧縮に適したファイルのみ圧縮データを持つ
+	for name, ok := range map[string]bool{"static.html": true, "style.css": true, "dynamic.html": false, "image.png": false} {
+		if _, err := r.Encoded(name, "gzip"); (err == nil) != ok {
+			t.Fatal(name, err)
+		}
+	}
+	if _, err := r.Encoded("static.html", "br"); err == nil {
+		t.Fatal("Error")
+	}
+
+	h := NewHandler(r)
+	// 圧縮データを返却する場合は、Data を呼び出さない
+	var called bool
+	h.Data = func(req *http.Request) (interface{}, error) {
+		called = true
+		return nil, nil
+	}
+	for _, v := range []struct {
+		url, accept, encoding string
+	}{
+		{"/static.html", "gzip, deflate", "gzip"},
+		{"/static.html", "br;q=1.0, *;q=0.5", "gzip"},
+		{"/static.html", "gzip;q=0", ""},
+		{"/static.html", "", ""},
+		{"/dynamic.html", "gzip", ""},
+	} {
+		req := httptest.NewRequest("GET", v.url, nil)
+		req.Header.Set("Accept-Encoding", v.accept)
+		w := httptest.NewRecorder()
+		called = false
+		h.ServeHTTP(w, req)
+		if w.Code != 200 || w.Header().Get("Content-Encoding") != v.encoding || w.Header().Get("Content-Type") != "text/html; charset=utf-8" {
+			t.Fatal(v, w.Code, w.Header())
+		}
+		if called != (v.encoding == "") {
+			t.Fatal(v, called)
+		}
+		// ETag は、返却するデータから作成する
+		body := w.Body.Bytes()
+		if etag := common.ETag(body); v.encoding == "gzip" {
+			etag = strings.TrimSuffix(etag, `"`) + `-gzip"`
+			if w.Header().Get("ETag") != etag {
+				t.Fatal(v, w.Header())
+			}
+		} else if w.Header().Get("ETag") != etag {
+			t.Fatal(v, w.Header())
+		}
+		if v.encoding == "gzip" {
+			zr, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, err = io.ReadAll(zr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !strings.HasPrefix(string(body), text) {
+			t.Fatal(v, string(body))
+		}
+	}
+
+	// 圧縮データのサイズも SumMaxSize に含める
+	var size int64
+	for _, f := range fsys {
+		size += int64(len(f.Data))
+	}
+	conf.SumMaxSize = size
+	if _, err := conf.New(); err == nil {
+		t.Fatal("Error")
+	}
+	conf.Compress = false
+	if _, err := conf.New(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 圧縮データは、テンプレートとしての解析結果から作成する
+func Test_HANDLER_COMPRESS_OUTPUT(t *testing.T) {
+	text := strings.Repeat("<p>static</p>", 100)
+	fsys := fstest.MapFS{
+		"comment.html": &fstest.MapFile{Data: []byte("hello {{/* secret note */}} world" + text)},
+		"escaped.html": &fstest.MapFile{Data: []byte("<!-- secret note -->" + text)},
+	}
+	for _, escape := range [][]string{nil, {".html"}} {
+		r, err := (&Config{FS: fsys, Cache: true, Compress: true, Escape: escape}).New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(r)
+		for _, name := range []string{"/comment.html", "/escaped.html"} {
+			var bodies []string
+			for _, accept := range []string{"", "gzip"} {
+				req := httptest.NewRequest("GET", name, nil)
+				req.Header.Set("Accept-Encoding", accept)
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				if w.Code != 200 || w.Header().Get("Content-Encoding") != accept {
+					t.Fatal(escape, name, w.Code, w.Header())
+				}
+				var body = w.Body.Bytes()
+				if accept == "gzip" {
+					zr, err := gzip.NewReader(w.Body)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if body, err = io.ReadAll(zr); err != nil {
+						t.Fatal(err)
+					}
+				}
+				bodies = append(bodies, string(body))
+			}
+			// 圧縮の有無に関わらず、同じ解析結果を返却する
+			if bodies[0] != bodies[1] {
+				t.Fatal(escape, name, bodies)
+			}
+			// テンプレートのコメントは常に、HTML のコメントは自動エスケープ時に除去される
+			if secret := strings.Contains(bodies[1], "secret note"); secret != (name == "/escaped.html" && escape == nil) {
+				t.Fatal(escape, name, bodies[1])
+			}
+		}
+	}
+}
+
+func Test_HANDLER_PARTIAL(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html":        &fstest.MapFile{Data: []byte(`{{layout "layout.html"}}{{define "content"}}{{import "header.html"}}{{partial "item.html" .}}{{end}}`)},
+		"layout.html":       &fstest.MapFile{Data: []byte(`<main>{{block "content" .}}{{end}}</main>`)},
+		"header.html":       &fstest.MapFile{Data: []byte(`<h1>header</h1>`)},
+		"item.html":         &fstest.MapFile{Data: []byte(`<p>item</p>`)},
+		"_footer.html":      &fstest.MapFile{Data: []byte(`<p>footer</p>`)},
+		"_parts/nav.html":   &fstest.MapFile{Data: []byte(`<nav></nav>`)},
+		"app/index.html":    &fstest.MapFile{Data: []byte(`<p>app</p>`)},
+		"app/_sidebar.html": &fstest.MapFile{Data: []byte(`<aside></aside>`)},
+	}
+	for _, cache := range []bool{true, false} {
+		r, err := (&Config{FS: fsys, Cache: cache}).New()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(r)
+		// layout, import, partial で読み込むファイル、"_" で始まるファイルは、既定では配信しない
+		for url, code := range map[string]int{
+			"/":                  200,
+			"/app/":              200,
+			"/layout.html":       404,
+			"/header.html":       404,
+			"/item.html":         404,
+			"/_footer.html":      404,
+			"/_parts/nav.html":   404,
+			"/app/_sidebar.html": 404,
+		} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+			if w.Code != code {
+				t.Fatal(cache, url, w.Code, w.Body.String())
+			}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Body.String() != "<main><h1>header</h1><p>item</p></main>" {
+			t.Fatal(cache, w.Body.String())
+		}
+
+		// Filter を指定した場合は、Filter の結果に従う
+		h.Filter = func(name string) bool { return name != "index.html" }
+		for url, code := range map[string]int{"/": 404, "/layout.html": 200, "/_footer.html": 200} {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+			if w.Code != code {
+				t.Fatal(cache, url, w.Code, w.Body.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Package helper : レンダーで共通して使用するヘルパ関数
+//
+// サービスと render コマンドで同じヘルパを登録することで、同じ解析結果を得る
+//
+//	r.AddHelper(helper.Funcs())
+//
+// 環境変数を参照する env 関数は、テンプレートから秘密情報を参照できるため、EnvFuncs で別に登録する
+//
+// 文字列を操作する関数は、パイプラインで使用できるよう、対象の文字列を最後の引数とする
+//
+//	{{.Name | replace "-" "_" | upper}}
+package helper
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Funcs : ヘルパ関数の一覧を返却する
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       Join,
+		"repeat":     func(count int, s string) string { return strings.Repeat(s, count) },
+		"indent":     Indent,
+		"default":    Default,
+		"toJSON":     ToJSON,
+		"date":       Date,
+		"add":        func(a, b int) int { return a + b },
+		"sub":        func(a, b int) int { return a - b },
+		"mul":        func(a, b int) int { return a * b },
+		"div":        Div,
+		"mod":        Mod,
+	}
+}
+
+// EnvFuncs : 環境変数を参照するヘルパ関数の一覧を返却する
+// テンプレートから全ての環境変数を参照できるため、テンプレートの作成者を信頼できる場合のみ登録すること
+//
+//	r.AddHelper(helper.EnvFuncs())
+func EnvFuncs() template.FuncMap {
+	return template.FuncMap{
+		"env": os.Getenv,
+	}
+}
+
+// Join : スライスの要素を文字列へ変換し、sep で連結する
+func Join(sep string, list interface{}) (string, error) {
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: %T is not a slice", list)
+	}
+	var result = make([]string, v.Len())
+	for i := range result {
+		result[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(result, sep), nil
+}
+
+// Indent : 各行の先頭に、指定した数の空白を付与する
+func Indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+// Default : 値がゼロ値、または空の場合は def を返却する
+func Default(def, value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || v.IsZero() {
+		return def
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		if v.Len() == 0 {
+			return def
+		}
+	}
+	return value
+}
+
+// ToJSON : 値を JSON 文字列へ変換する
+func ToJSON(value interface{}) (string, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// Date : 日時を指定した書式の文字列へ変換する。文字列の場合は RFC3339 形式として扱う
+func Date(layout string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		return v.Format(layout), nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", fmt.Errorf("date: %w", err)
+		}
+		return t.Format(layout), nil
+	}
+	return "", fmt.Errorf("date: %T is not a time", value)
+}
+
+// Div : a を b で割った値を返却する
+func Div(a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("div: division by zero")
+	}
+	return a / b, nil
+}
+
+// Mod : a を b で割った余りを返却する
+func Mod(a, b int) (int, error) {
+	if b == 0 {
+		return 0, fmt.Errorf("mod: division by zero")
+	}
+	return a % b, nil
+}
@@ -0,0 +1,55 @@
+package helper
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func Test_FUNCS(t *testing.T) {
+	var data = map[string]interface{}{
+		"Name":  " foo-bar ",
+		"Tags":  []string{"a", "b"},
+		"Nums":  []int{1, 2},
+		"Empty": "",
+		"Time":  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"Count": 7,
+	}
+	for text, expected := range map[string]string{
+		`{{.Name | trim | replace "-" "_" | upper}}`:                                "FOO_BAR",
+		`{{"ABC" | lower}}`:                                                         "abc",
+		`{{"a.html" | trimSuffix ".html" | trimPrefix "a"}}|`:                       "|",
+		`{{contains "bar" .Name}} {{hasPrefix " f" .Name}} {{hasSuffix "x" .Name}}`: "true true false",
+		`{{join "," .Tags}} {{join "+" .Nums}} {{len (split "," "a,b,c")}}`:         "a,b 1+2 3",
+		`{{repeat 3 "ab"}}`:   "ababab",
+		`{{indent 2 "a\nb"}}`: "  a\n  b",
+		`{{default "none" .Empty}} {{default "none" .Undefined}} {{default 0 .Count}}`: "none none 7",
+		`{{toJSON .Tags}}`: `["a","b"]`,
+		`{{date "2006/01/02" .Time}} {{date "15:04" "2020-01-02T03:04:05Z"}}`:                  "2020/01/02 03:04",
+		`{{add .Count 1}} {{sub .Count 1}} {{mul .Count 2}} {{div .Count 2}} {{mod .Count 2}}`: "8 6 14 3 1",
+	} {
+		tmpl := template.Must(template.New("").Funcs(Funcs()).Parse(text))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil || buf.String() != expected {
+			t.Fatal(text, buf.String(), err)
+		}
+	}
+	// env は、EnvFuncs を登録した場合のみ使用できる
+	if _, ok := Funcs()["env"]; ok {
+		t.Fatal("env is registered")
+	}
+	t.Setenv("RENDER_HELPER_TEST", "value")
+	tmpl := template.Must(template.New("").Funcs(EnvFuncs()).Parse(`{{env "RENDER_HELPER_TEST"}}`))
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil || buf.String() != "value" {
+		t.Fatal(buf.String(), err)
+	}
+	for _, text := range []string{`{{div 1 0}}`, `{{mod 1 0}}`, `{{join "," 1}}`, `{{date "2006" 1}}`, `{{date "2006" "x"}}`} {
+		tmpl := template.Must(template.New("").Funcs(Funcs()).Parse(text))
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err == nil {
+			t.Fatal(text)
+		}
+	}
+}
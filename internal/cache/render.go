@@ -1,9 +1,13 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"regexp"
 	"sync"
 	"text/template"
+	"text/template/parse"
 
 	"github.com/ochipin/render/core"
 	"github.com/ochipin/render/internal/common"
@@ -12,9 +16,13 @@ import (
 // Render : キャッシュありのRenderオブジェクトを管理する構造体
 type Render struct {
 	mu       sync.Mutex
-	filelist map[string]string
-	binlist  map[string][]byte
+	store    *store    // レンダーファイルのスナップショット
+	snapshot *snapshot // base の作成元となったスナップショット
+	base     map[bool]common.Template
+	contexts template.FuncMap // 第1引数が context.Context 型のヘルパ関数
 	exclude  *regexp.Regexp
+	escape   []string
+	locale   string // ロケール未指定時に使用するロケール
 	funcs    template.FuncMap
 }
 
@@ -26,11 +34,14 @@ func (r *Render) Copy() core.Render {
 		funcs[k] = v
 	}
 	// レンダーオブジェクトを返却する
+	// スナップショットはコピー元と共有し、ヘルパ登録済みのテンプレートセットは、コピー先で再作成する
 	return &Render{
-		filelist: r.filelist,
-		binlist:  r.binlist,
-		exclude:  r.exclude,
-		funcs:    funcs,
+		store:   r.store,
+		base:    make(map[bool]common.Template),
+		exclude: r.exclude,
+		escape:  r.escape,
+		funcs:   funcs,
+		locale:  r.locale,
 	}
 }
 
@@ -46,6 +57,7 @@ func (r *Render) HasHelper(name string) bool {
 func (r *Render) Helper(i interface{}) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.base = make(map[bool]common.Template)
 	// 登録されたヘルパは、構造体名.メソッド名でコール可能
 	return common.Helpers(r.funcs, i, common.HelperStruct)
 }
@@ -54,6 +66,7 @@ func (r *Render) Helper(i interface{}) error {
 func (r *Render) LargeHelper(i interface{}) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.base = make(map[bool]common.Template)
 	// 登録されたヘルパは、構造体のメソッド名のみでコール可能
 	return common.Helpers(r.funcs, i, common.HelperLarge)
 }
@@ -62,6 +75,7 @@ func (r *Render) LargeHelper(i interface{}) error {
 func (r *Render) SmallHelper(i interface{}) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	r.base = make(map[bool]common.Template)
 	// 登録されたヘルパは、構造体のメソッド名のみでコール可能
 	// メソッド名が、Hello の場合、呼び出す側は hello でコール
 	return common.Helpers(r.funcs, i, common.HelperSmall)
@@ -69,97 +83,354 @@ func (r *Render) SmallHelper(i interface{}) error {
 
 // AddHelper : template.FuncMap そのものを登録する
 func (r *Render) AddHelper(helper template.FuncMap) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.base = make(map[bool]common.Template)
 	return common.FuncMapHelper(helper, r.funcs)
 }
 
 // RenderString : 文字列レンダーを処理する
 func (r *Render) RenderString(text string, data interface{}) ([]byte, error) {
 	// 所持しているレンダーファイルを解析する
-	tmpl, err := r.template(data)
+	snap := r.store.load()
+	tmpl, err := r.template(context.Background(), snap, common.IsEscape("*", r.escape), data, common.Options{})
 	if err != nil {
 		return nil, err
 	}
 	// 渡された文字列ベースのテンプレートを解析
-	tmpl, err = tmpl.New("string").Parse(text)
+	trees, err := common.Parse("string", text, r.store.delims(""))
 	if err != nil {
 		return nil, common.RenderError(err, nil, text)
 	}
+	for name, tree := range common.Interruptible(trees, text, r.store.delims("")) {
+		if err := tmpl.AddParseTree(name, tree); err != nil {
+			return nil, common.RenderError(err, nil, text)
+		}
+	}
 	// 解析結果を返却する
-	return common.Template(tmpl, "string", r.exclude, data)
+	buf, err := common.Execute(tmpl, "string", r.exclude, data)
+	return buf, common.Diagnose(err, func(name string) (string, bool) {
+		if name == "string" {
+			return text, true
+		}
+		return snap.source(name)
+	})
 }
 
 // Render : 指定した名前でデータでテンプレートファイルの解析結果を取得する
 func (r *Render) Render(tmplname string, data interface{}) ([]byte, error) {
+	return r.RenderContext(context.Background(), tmplname, data)
+}
+
+// RenderContext : コンテキストを指定して、テンプレートファイルの解析結果を取得する
+func (r *Render) RenderContext(ctx context.Context, tmplname string, data interface{}) ([]byte, error) {
 	// バイナリファイルリストから指定された名前で登録されているバイナリファイルを取得する
-	if v, ok := r.binlist[tmplname]; ok {
+	if v, ok := r.store.load().binlist[tmplname]; ok {
 		return v, nil
 	}
-	// レンダーファイルリストから指定された名前で登録されているレンダーファイルを取得する
-	if _, ok := r.filelist[tmplname]; !ok {
-		return nil, &core.TemplateError{Message: "template: \"" + tmplname + "\" not defined"}
+	// 解析結果をバッファへ格納し、返却する
+	var buf bytes.Buffer
+	if err := r.render(ctx, &buf, common.Options{}, tmplname, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTo : 指定した名前でデータでテンプレートファイルの解析結果を w へ書き込む
+func (r *Render) RenderTo(w io.Writer, tmplname string, data interface{}) error {
+	return r.render(context.Background(), w, common.Options{}, tmplname, data)
+}
+
+// RenderWithLayout : 指定したレイアウトの中に、テンプレートファイルの解析結果を埋め込む
+func (r *Render) RenderWithLayout(layout, tmplname string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.render(context.Background(), &buf, common.Options{Layout: layout}, tmplname, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderResult : 指定した名前でデータでテンプレートファイルの解析結果と、ファイルの情報を取得する
+func (r *Render) RenderResult(tmplname string, data interface{}) (*core.Result, error) {
+	var buf bytes.Buffer
+	var trace = &common.Trace{}
+	if err := r.render(context.Background(), &buf, common.Options{Trace: trace}, tmplname, data); err != nil {
+		return nil, err
+	}
+	info, err := r.Stat(tmplname)
+	if err != nil {
+		return nil, err
 	}
-	// レンダーファイルを解析する
-	tmpl, err := r.template(data)
+	origin, err := r.Origin(tmplname)
 	if err != nil {
 		return nil, err
 	}
+	return common.Result(info, origin, buf.Bytes(), trace), nil
+}
 
-	// 解析結果を返却する
-	return common.Template(tmpl, tmplname, r.exclude, data)
+// RenderLocale : ロケールを考慮したテンプレートファイルの解析結果を取得する
+// index.html, ja-JP の場合は index.ja-JP.html, index.ja.html, index.html の順に探索する
+func (r *Render) RenderLocale(tmplname, locale string, data interface{}) ([]byte, error) {
+	return r.RenderLocaleContext(context.Background(), tmplname, locale, data)
 }
 
-// テンプレートを解析
-func (r *Render) template(data interface{}) (tmpl *template.Template, err error) {
-	var funcs = make(template.FuncMap)
+// RenderLocaleContext : コンテキストを指定して、ロケールを考慮したテンプレートファイルの解析結果を取得する
+func (r *Render) RenderLocaleContext(ctx context.Context, tmplname, locale string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.render(ctx, &buf, common.Options{Locale: locale}, tmplname, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-	// 一旦ヘルパ関数をコピーする
-	for k, v := range r.funcs {
-		funcs[k] = v
+// 指定した名前のバイナリファイル、またはテンプレートファイルの解析結果を w へ書き込む
+// レイアウトが空文字の場合は、テンプレートファイルに記述された {{layout "name"}} のレイアウトを使用する
+func (r *Render) render(ctx context.Context, w io.Writer, opts common.Options, tmplname string, data interface{}) error {
+	// レンダー中に再読み込みされた場合でも、同じスナップショットを使用する
+	snap := r.store.load()
+	// ロケールが指定されている場合は、ロケールに一致するファイルを使用する
+	tmplname = common.Localize(tmplname, opts.Locale, snap.exists)
+	// バイナリファイルリストから指定された名前で登録されているバイナリファイルを書き込む
+	if v, ok := snap.binlist[tmplname]; ok {
+		_, err := w.Write(v)
+		return err
 	}
-	// import : format で指定したテンプレート名を元に、テンプレートファイルの内容をロードする
-	funcs["import"] = func(format string, i ...interface{}) (string, error) {
-		return common.Import(tmpl, data, format, i...)
+	// レンダーファイルリストから指定された名前で登録されているレンダーファイルを取得する
+	if _, ok := snap.filelist[tmplname]; !ok {
+		return common.NotDefined(tmplname, core.ErrNotFound)
 	}
-	// hastemplate : 指定したテンプレート名が存在するかチェックする
-	funcs["hastemplate"] = func(format string, i ...interface{}) bool {
-		return common.HasTemplate(tmpl, format, i...)
+	// レンダーファイルを解析する。自動エスケープの対象の場合は、html/template を使用する
+	tmpl, err := r.template(ctx, snap, common.IsEscape(tmplname, r.escape), data, opts)
+	if err != nil {
+		return err
 	}
-	// レンダーファイルリストから、テンプレートを作成する
-	for tmplname, tmpldata := range r.filelist {
-		if tmpl == nil {
-			tmpl, err = template.New(tmplname).Funcs(funcs).Parse(tmpldata)
-		} else {
-			tmpl, err = tmpl.New(tmplname).Parse(tmpldata)
+	// レイアウトを外側から順に取得する
+	chain, err := common.Layouts(tmplname, opts.Layout, snap.lookup)
+	if err != nil {
+		return err
+	}
+	// 外側のレイアウトから順に構文木を重ね合わせて登録し直し、内側で定義した define でブロックを上書きする
+	if len(chain) > 1 {
+		trees := common.LayoutTrees(chain, func(name string) []*parse.Tree {
+			return snap.files[name]
+		})
+		for _, tree := range trees {
+			if err := tmpl.AddParseTree(tree.Name, tree); err != nil {
+				return common.RenderError(err, nil, snap.filelist[tree.ParseName])
+			}
 		}
-		// エラーが発生した場合、エラーを返却する
-		if err != nil {
-			return nil, common.RenderError(err, tmpl, tmpldata)
+	}
+
+	// 最も外側のレイアウトから解析し、結果を書き込む
+	err = common.ExecuteTo(ctx, w, tmpl, chain[0], r.exclude, data)
+	return common.Diagnose(err, snap.source)
+}
+
+// 指定した名前のファイルが存在するか確認する
+func (snap *snapshot) exists(name string) bool {
+	if _, ok := snap.filelist[name]; ok {
+		return true
+	}
+	_, ok := snap.binlist[name]
+	return ok
+}
+
+// 指定したテンプレートファイルの元の内容を取得する
+func (snap *snapshot) source(name string) (string, bool) {
+	text, ok := snap.filelist[name]
+	return text, ok
+}
+
+// 指定したテンプレートファイルの構文木を取得する
+func (snap *snapshot) lookup(name string) (*parse.Tree, error) {
+	for _, tree := range snap.files[name] {
+		if tree.Name == name {
+			return tree, nil
+		}
+	}
+	return nil, common.NotDefined(name, core.ErrNotFound)
+}
+
+// ヘルパ登録済みのテンプレートセットを取得する。未作成の場合は、構文木から作成する
+func (r *Render) prepare(snap *snapshot, escape bool) (common.Template, template.FuncMap, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 構文木作成時にエラーが発生していた場合は、エラーを返却する
+	if snap.parseerr != nil {
+		return nil, nil, snap.parseerr
+	}
+	// スナップショットが入れ替わっていた場合は、テンプレートセットを再作成する
+	if r.snapshot != snap {
+		r.snapshot = snap
+		r.base = make(map[bool]common.Template)
+	}
+	if base, ok := r.base[escape]; ok {
+		return base, r.contexts, nil
+	}
+	// 解析済みの構文木をテンプレートセットへ登録する
+	base := common.NewTemplate(escape).Funcs(r.funcs)
+	for _, tree := range snap.trees {
+		if err := base.AddParseTree(tree.Name, tree); err != nil {
+			return nil, nil, common.RenderError(err, nil, snap.filelist[tree.ParseName])
 		}
 	}
+	r.base[escape] = base
+	r.contexts = common.ContextFuncs(r.funcs)
+	return base, r.contexts, nil
+}
+
+// テンプレートを解析
+// ロケールが指定されている場合、import, hastemplate, partial はロケールに一致するファイルを使用する
+func (r *Render) template(ctx context.Context, snap *snapshot, escape bool, data interface{}, opts common.Options) (tmpl common.Template, err error) {
+	base, contexts, err := r.prepare(snap, escape)
+	if err != nil {
+		return nil, err
+	}
+	// テンプレートセットを複製し、レンダー毎のデータを参照する import, hastemplate を登録する
+	if tmpl, err = base.Clone(); err != nil {
+		return nil, err
+	}
+	// ロケールに一致するテンプレート名を取得する
+	localize := func(name string) string {
+		return common.Localize(name, opts.Locale, func(name string) bool {
+			return tmpl.Lookup(name) != nil
+		})
+	}
+	// 第1引数が context.Context 型のヘルパ関数には、ctx を渡す
+	tmpl.Funcs(common.BindContext(ctx, contexts))
+	tmpl.Funcs(template.FuncMap{
+		// import : format で指定したテンプレート名を元に、テンプレートファイルの内容をロードする
+		"import": func(format string, i ...interface{}) (interface{}, error) {
+			name := localize(common.TemplateName(format, i...))
+			opts.Trace.Import(name)
+			buf, err := common.Partial(ctx, tmpl, name, data)
+			return tmpl.Safe(buf), err
+		},
+		// hastemplate : 指定したテンプレート名が存在するかチェックする
+		"hastemplate": func(format string, i ...interface{}) bool {
+			return tmpl.Lookup(localize(common.TemplateName(format, i...))) != nil
+		},
+		// partial : 指定したテンプレート名のテンプレートを、指定したデータで解析する
+		"partial": func(name string, arg interface{}) (interface{}, error) {
+			name = localize(name)
+			opts.Trace.Import(name)
+			buf, err := common.Partial(ctx, tmpl, name, arg)
+			return tmpl.Safe(buf), err
+		},
+		// cache : 指定したテンプレート名のテンプレートの解析結果を、キーとキーの要素毎に ttl の間保持する
+		"cache": func(key string, ttl interface{}, name string, parts ...interface{}) (interface{}, error) {
+			d, err := common.TTL(ttl)
+			if err != nil {
+				return nil, err
+			}
+			name = localize(name)
+			opts.Trace.Import(name)
+			buf, err := r.store.fragments.Get(key, parts, name+"\x00"+opts.Locale, d, func() (string, error) {
+				return common.Partial(ctx, tmpl, name, data)
+			})
+			return tmpl.Safe(buf), err
+		},
+		// layout : レイアウト指定。解析時は何も出力しない
+		"layout": common.Layout,
+		"dict":   common.Dict,
+		"list":   common.List,
+		// アクションの間で、コンテキストのキャンセルを確認する
+		common.Checkpoint: common.CheckContext(ctx),
+	})
+	// メッセージカタログが指定されている場合は、t, tn を登録する
+	if r.store.messages != nil {
+		translator := common.NewTranslator(opts.Locale, r.locale, func(locale string) (common.Catalog, error) {
+			return snap.catalogs[locale], nil
+		})
+		tmpl.Funcs(template.FuncMap{
+			"t":  translator.T,
+			"tn": translator.TN,
+		})
+	}
 
-	return tmpl, err
+	return tmpl, nil
+}
+
+// Reload : レンダーファイルを再読み込みする。Copy したレンダーオブジェクトにも反映される
+// 読み込み、または構文木の作成に失敗した場合は、エラーを返却し、再読み込み前のファイルを使用し続ける
+func (r *Render) Reload() error {
+	return r.store.reload()
+}
+
+// Version : 使用中のレンダーファイルのバージョンを取得する
+func (r *Render) Version() string {
+	return r.store.load().version
+}
+
+// Origin : 指定した名前のファイルを読み込んだディレクトリを取得する
+func (r *Render) Origin(name string) (string, error) {
+	origin, ok := r.store.load().origins[name]
+	if !ok {
+		return "", common.NotDefined(name, core.ErrNotFound)
+	}
+	return origin, nil
+}
+
+// Stat : 指定した名前のファイルの情報を取得する。ETag は読み込み時のファイルの内容から求める
+func (r *Render) Stat(name string) (*core.FileInfo, error) {
+	info, ok := r.store.load().infos[name]
+	if !ok {
+		return nil, common.NotDefined(name, core.ErrNotFound)
+	}
+	result := *info
+	return &result, nil
+}
+
+// Encoded : 指定した名前のファイルの、指定したエンコーディング("gzip")の圧縮データを取得する
+// 圧縮データは、Config.Compress を指定した場合に、読み込み時に作成する
+func (r *Render) Encoded(name, encoding string) ([]byte, error) {
+	data, ok := r.store.load().encoded[name][encoding]
+	if !ok {
+		return nil, &core.TemplateError{Message: "template: \"" + name + "\" has no " + encoding + " encoding", Name: name, Category: core.ErrNotEncoded}
+	}
+	return data, nil
+}
+
+// IsPartial : 指定した名前のファイルが、import, partial, layout, cache で読み込む部品のファイルか確認する
+// 読み込み時に作成したテンプレートの依存関係と、"_" で始まるファイル名、ディレクトリ名から判定する
+func (r *Render) IsPartial(name string) bool {
+	return common.IsPartial(r.store.load().graph, name)
+}
+
+// Invalidate : cache 関数で保持している、指定したキーの解析結果を削除する
+// キーの要素を省略した場合は、キーが一致する全ての解析結果を削除する
+func (r *Render) Invalidate(key string, parts ...interface{}) {
+	r.store.fragments.Invalidate(key, parts...)
+}
+
+// Close : ファイルの監視を停止する
+func (r *Render) Close() error {
+	r.store.close()
+	return nil
 }
 
 // CreateRender : レンダーオブジェクトを生成する
 func CreateRender(c *common.Config) core.Render {
-	var filelist = make(map[string]string)
-	var binlist = make(map[string][]byte)
-
-	// ファイルリスト一覧の情報をもとに、バイナリ、レンダーファイルリストを作成する
-	for _, v := range c.Files {
-		if v.IsBinary {
-			// バイナリファイルリストを作成
-			binlist[v.FileName] = v.FileData
-		} else {
-			// レンダーファイルリストを作成
-			filelist[v.FileName] = string(v.FileData)
-		}
+	var s = &store{
+		loader: c.Loader,
+		delims: func(name string) common.Delims {
+			return common.DelimsOf(name, c.Delims, c.ExtDelims)
+		},
+		messages:  c.Messages,
+		fragments: common.NewFragments(c.Fragments),
+	}
+	s.value.Store(s.create(c.Files))
+	// 監視間隔が指定されている場合は、ファイルの変更を監視する
+	if c.Watch > 0 && c.FS != nil {
+		s.watch(c.FS, c.Watch, c.OnReload)
 	}
 
 	return &Render{
-		filelist: filelist,
-		binlist:  binlist,
-		exclude:  c.Exclude,
-		funcs:    make(template.FuncMap),
+		store:   s,
+		base:    make(map[bool]common.Template),
+		exclude: c.Exclude,
+		escape:  c.Escape,
+		locale:  c.Locale,
+		funcs:   make(template.FuncMap),
 	}
 }
@@ -1,9 +1,18 @@
 package cache
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/ochipin/render/core"
 	"github.com/ochipin/render/internal/common"
@@ -150,3 +159,329 @@ func Test_HELPER_TEST(t *testing.T) {
 		t.Fatal("Error")
 	}
 }
+
+func Test_RENDER_PARSE_ONCE(t *testing.T) {
+	r := CreateRender(&common.Config{
+		Directory: "test",
+		Files: []*common.File{
+			&common.File{
+				IsBinary: false,
+				FileName: "app/index.html",
+				FileData: []byte(`<p>{{import "app/name.html"}}</p>`),
+			},
+			&common.File{
+				IsBinary: false,
+				FileName: "app/name.html",
+				FileData: []byte(`{{.}}`),
+			},
+		},
+	})
+	// 複製したテンプレートセットで、レンダー毎のデータが import 先に渡るか確認する
+	for _, name := range []string{"name1", "name2"} {
+		buf, err := r.Render("app/index.html", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "<p>"+name+"</p>" {
+			t.Fatal(string(buf))
+		}
+	}
+	// 未登録のヘルパは、実行時にエラーとなる
+	if _, err := r.RenderString("{{status}}", nil); err == nil {
+		t.Fatal("Error")
+	}
+	// ヘルパ登録後は、テンプレートセットが再作成される
+	r.AddHelper(template.FuncMap{
+		"status": func() string { return "status" },
+	})
+	buf, err := r.RenderString("{{status}}", nil)
+	if err != nil || string(buf) != "status" {
+		t.Fatal(err)
+	}
+}
+
+func Test_RENDER_ESCAPE(t *testing.T) {
+	r := CreateRender(&common.Config{
+		Directory: "test",
+		Escape:    []string{".html"},
+		Files: []*common.File{
+			&common.File{
+				IsBinary: false,
+				FileName: "app/index.html",
+				FileData: []byte(`<a href="/?q={{.}}">{{import "app/name.html"}}</a>`),
+			},
+			&common.File{
+				IsBinary: false,
+				FileName: "app/name.html",
+				FileData: []byte(`<b>{{.}}</b>`),
+			},
+			&common.File{
+				IsBinary: false,
+				FileName: "app/name.text",
+				FileData: []byte(`{{.}}`),
+			},
+		},
+	})
+	// .html は文脈に応じてエスケープされ、import 結果は再エスケープされない
+	buf, err := r.Render("app/index.html", "<x&y>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != `<a href="/?q=%3cx%26y%3e"><b>&lt;x&amp;y&gt;</b></a>` {
+		t.Fatal(string(buf))
+	}
+	// .text は text/template のまま処理される
+	buf, err = r.Render("app/name.text", "<x&y>")
+	if err != nil || string(buf) != "<x&y>" {
+		t.Fatal(string(buf))
+	}
+	// "*" を指定した場合は、文字列レンダーも自動エスケープの対象となる
+	r = CreateRender(&common.Config{Directory: "test", Escape: []string{"*"}})
+	buf, err = r.RenderString("{{.}}", "<b>")
+	if err != nil || strings.Contains(string(buf), "<b>") {
+		t.Fatal(string(buf))
+	}
+}
+
+func Test_RENDER_TO(t *testing.T) {
+	r := CreateRenderSample()
+	// バイナリファイルを書き込む
+	var buf bytes.Buffer
+	if err := r.RenderTo(&buf, "images/name.png", nil); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "<ping file>" {
+		t.Fatal(buf.String())
+	}
+	// テンプレートファイルの解析結果を書き込む
+	buf.Reset()
+	if err := r.RenderTo(&buf, "app/index.html", nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "app.tmpl.html template") == false {
+		t.Fatal(buf.String())
+	}
+	// 未登録のレンダーはエラーとなる
+	if err := r.RenderTo(&buf, "app/index.text", nil); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+type contextKey struct{}
+
+func Test_RENDER_CONTEXT(t *testing.T) {
+	r := CreateRender(&common.Config{
+		Directory: "test",
+		Files: []*common.File{
+			&common.File{
+				IsBinary: false,
+				FileName: "app/index.html",
+				FileData: []byte(`{{user}} {{join "-" "a" "b"}}`),
+			},
+			&common.File{
+				IsBinary: false,
+				FileName: "app/loop.html",
+				FileData: []byte(`{{import "app/loop.html"}}`),
+			},
+		},
+	})
+	// 第1引数が context.Context 型のヘルパには、コンテキストが渡される
+	r.AddHelper(template.FuncMap{
+		"user": func(ctx context.Context) string {
+			return ctx.Value(contextKey{}).(string)
+		},
+		"join": func(ctx context.Context, sep string, s ...string) string {
+			return strings.Join(s, sep)
+		},
+	})
+	ctx := context.WithValue(context.Background(), contextKey{}, "user1")
+	buf, err := r.RenderContext(ctx, "app/index.html", nil)
+	if err != nil || string(buf) != "user1 a-b" {
+		t.Fatal(string(buf), err)
+	}
+	// キャンセル済みのコンテキストの場合、ContextError となる
+	cancelctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = r.RenderContext(cancelctx, "app/index.html", nil)
+	if _, ok := err.(*core.ContextError); !ok || errors.Is(err, context.Canceled) == false {
+		t.Fatal(err)
+	}
+	// 無限に import を繰り返す場合でも、タイムアウトで中断される
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err = r.RenderContext(timeout, "app/loop.html", nil)
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		t.Fatal(err)
+	}
+}
+
+func Test_RENDER_LAYOUT(t *testing.T) {
+	var files []*common.File
+	for name, data := range map[string]string{
+		"base.html":   `<html>{{block "title" .}}default{{end}}|{{block "content" .}}{{end}}</html>`,
+		"layout.html": `{{layout "base.html"}}{{define "content"}}<main>{{block "main" .}}{{end}}</main>{{end}}`,
+		"page.html":   `{{layout "layout.html"}}{{define "title"}}Page{{end}}{{define "main"}}{{.}}{{end}}`,
+		"other.html":  `{{define "content"}}Other{{end}}`,
+		"cycle.html":  `{{layout "cycle.html"}}`,
+		"side.html":   `{{layout "base.html"}}{{define "title"}}Side{{end}}`,
+		"zzz.html":    `{{define "content"}}Leak{{end}}`,
+	} {
+		files = append(files, &common.File{FileName: name, FileData: []byte(data)})
+	}
+	r := CreateRender(&common.Config{Directory: "test", Files: files})
+	// テンプレート内の layout 指定で、レイアウトを入れ子にする
+	buf, err := r.Render("page.html", "Hello World")
+	if err != nil || string(buf) != "<html>Page|<main>Hello World</main></html>" {
+		t.Fatal(string(buf), err)
+	}
+	// RenderWithLayout でレイアウトを指定する。他のページで定義した define の影響は受けない
+	buf, err = r.RenderWithLayout("base.html", "other.html", nil)
+	if err != nil || string(buf) != "<html>default|Other</html>" {
+		t.Fatal(string(buf), err)
+	}
+	// 空のブロックは、他のファイルで定義した define で上書きされない
+	buf, err = r.Render("side.html", nil)
+	if err != nil || string(buf) != "<html>Side|</html>" {
+		t.Fatal(string(buf), err)
+	}
+	// 存在しないレイアウトを指定した場合は、エラーとなる
+	if _, err := r.RenderWithLayout("undefined.html", "other.html", nil); err == nil {
+		t.Fatal("Error")
+	}
+	// レイアウトが循環している場合は、エラーとなる
+	if _, err := r.Render("cycle.html", nil); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func Test_RENDER_PARTIAL(t *testing.T) {
+	r := CreateRender(&common.Config{
+		Directory: "test",
+		Escape:    []string{".html"},
+		Files: []*common.File{
+			&common.File{
+				FileName: "index.html",
+				FileData: []byte(`<ul>{{range .}}{{partial "card.html" (dict "Item" . "Tags" (list "x" "y"))}}{{end}}</ul>`),
+			},
+			&common.File{
+				FileName: "card.html",
+				FileData: []byte(`<li>{{.Item}}{{range .Tags}}#{{.}}{{end}}</li>`),
+			},
+		},
+	})
+	// partial で、テンプレート毎に異なるデータを渡す
+	buf, err := r.Render("index.html", []string{"a", "<b>"})
+	if err != nil || string(buf) != "<ul><li>a#x#y</li><li>&lt;b&gt;#x#y</li></ul>" {
+		t.Fatal(string(buf), err)
+	}
+}
+
+func Test_RENDER_WATCH(t *testing.T) {
+	dir := t.TempDir()
+	write := func(text string, mod time.Time) {
+		fname := filepath.Join(dir, "index.html")
+		if err := os.WriteFile(fname, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// 更新日時の精度に依存しないよう、更新日時を明示的に変更する
+		if err := os.Chtimes(fname, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loader := func() ([]*common.File, error) {
+		buf, err := os.ReadFile(filepath.Join(dir, "index.html"))
+		if err != nil {
+			return nil, err
+		}
+		return []*common.File{{FileName: "index.html", FileData: buf}}, nil
+	}
+	now := time.Now()
+	write("v1", now)
+	files, _ := loader()
+
+	reloaded := make(chan error, 10)
+	r := CreateRender(&common.Config{
+		FS:       os.DirFS(dir),
+		Files:    files,
+		Loader:   loader,
+		Watch:    10 * time.Millisecond,
+		OnReload: func(err error) { reloaded <- err },
+	})
+	defer r.Close()
+	r2 := r.Copy()
+
+	// ファイルを変更すると、再読み込みされる
+	write("v2", now.Add(time.Second))
+	if err := <-reloaded; err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []core.Render{r, r2} {
+		buf, err := v.Render("index.html", nil)
+		if err != nil || string(buf) != "v2" {
+			t.Fatal(string(buf), err)
+		}
+	}
+
+	// 構文エラーのあるファイルへ変更した場合、入れ替え前のファイルを使用し続ける
+	write("{{", now.Add(2*time.Second))
+	if err := <-reloaded; err == nil {
+		t.Fatal("Error")
+	}
+	if buf, err := r.Render("index.html", nil); err != nil || string(buf) != "v2" {
+		t.Fatal(string(buf), err)
+	}
+
+	// 監視停止後は、再読み込みされない
+	r.Close()
+	r.Close()
+	time.Sleep(20 * time.Millisecond)
+	write("v3", now.Add(3*time.Second))
+	select {
+	case err := <-reloaded:
+		t.Fatal("Error", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if buf, err := r.Render("index.html", nil); err != nil || string(buf) != "v2" {
+		t.Fatal(string(buf), err)
+	}
+}
+
+func Test_RENDER_RELOAD(t *testing.T) {
+	var mu sync.Mutex
+	var text = "v0"
+	loader := func() ([]*common.File, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return []*common.File{{FileName: "index.html", FileData: []byte(text)}}, nil
+	}
+	files, _ := loader()
+	r := CreateRender(&common.Config{Files: files, Loader: loader})
+
+	// レンダー中に再読み込みしても、いずれかのバージョンの結果が返却される
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				buf, err := r.Copy().Render("index.html", nil)
+				if err != nil || !strings.HasPrefix(string(buf), "v") {
+					t.Error(string(buf), err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 10; i++ {
+		mu.Lock()
+		text = "v" + strconv.Itoa(i)
+		mu.Unlock()
+		if err := r.Reload(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	if buf, err := r.Render("index.html", nil); err != nil || string(buf) != "v10" {
+		t.Fatal(string(buf), err)
+	}
+}
@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/template/parse"
+	"time"
+
+	"github.com/ochipin/render/core"
+	"github.com/ochipin/render/internal/common"
+)
+
+// snapshot : 読み込んだレンダーファイルの情報を管理する構造体。作成後は変更しない
+type snapshot struct {
+	filelist map[string]string
+	binlist  map[string][]byte
+	origins  map[string]string            // ファイル毎の読み込み元ディレクトリ
+	infos    map[string]*core.FileInfo    // ファイル毎の情報
+	encoded  map[string]map[string][]byte // ファイル毎、エンコーディング毎の圧縮データ
+	trees    []*parse.Tree                // 解析済みのテンプレート構文木
+	files    map[string][]*parse.Tree     // ファイル毎の構文木
+	graph    *common.Graph                // テンプレートの依存関係
+	parseerr error                        // 構文木作成時に発生したエラー
+	catalogs map[string]common.Catalog    // ロケール毎のメッセージカタログ
+	version  string                       // ファイル名と内容から求めたハッシュ値
+}
+
+// ファイルリスト一覧から、スナップショットを作成する
+// delims には、ファイル名毎の区切り文字を返却する関数を指定する
+func newSnapshot(list []*common.File, delims func(string) common.Delims) *snapshot {
+	var filelist = make(map[string]string)
+	var binlist = make(map[string][]byte)
+	var origins = make(map[string]string)
+	var infos = make(map[string]*core.FileInfo)
+	var encoded = make(map[string]map[string][]byte)
+
+	// ファイルリスト一覧の情報をもとに、バイナリ、レンダーファイルリストを作成する
+	for _, v := range list {
+		origins[v.FileName] = v.Origin
+		if len(v.Encoded) > 0 {
+			encoded[v.FileName] = v.Encoded
+		}
+		infos[v.FileName] = &core.FileInfo{
+			Name:     v.FileName,
+			Size:     int64(len(v.FileData)),
+			ModTime:  v.ModTime,
+			ETag:     common.ETag(v.FileData),
+			IsBinary: v.IsBinary,
+		}
+		if v.IsBinary {
+			// バイナリファイルリストを作成
+			binlist[v.FileName] = v.FileData
+		} else {
+			// レンダーファイルリストを作成
+			filelist[v.FileName] = string(v.FileData)
+		}
+	}
+
+	// レンダーファイルの構文木を作成する。ファイル名順に処理し、define の上書き順を固定する
+	var names []string
+	for name := range filelist {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var trees []*parse.Tree
+	var files = make(map[string][]*parse.Tree)
+	var parseerr error
+	for _, name := range names {
+		t, err := common.Parse(name, filelist[name], delims(name))
+		// エラーが発生した場合、Render 実行時にエラーを返却する
+		if err != nil {
+			parseerr = common.RenderError(err, nil, filelist[name])
+			break
+		}
+		files[name] = sortTrees(common.Interruptible(t, filelist[name], delims(name)))
+		trees = append(trees, files[name]...)
+	}
+
+	// テンプレートの依存関係を作成する。パースエラーは parseerr で扱うため、無視する
+	var graph = common.NewGraph()
+	for _, name := range names {
+		graph.Add(name, filelist[name], delims(name))
+	}
+
+	return &snapshot{
+		filelist: filelist,
+		binlist:  binlist,
+		origins:  origins,
+		infos:    infos,
+		encoded:  encoded,
+		trees:    trees,
+		files:    files,
+		graph:    graph,
+		parseerr: parseerr,
+		version:  version(list),
+	}
+}
+
+// ファイル名と内容から、スナップショットのバージョンとなるハッシュ値を求める
+func version(list []*common.File) string {
+	var sorted = append([]*common.File(nil), list...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].FileName < sorted[j].FileName
+	})
+	var hash = sha256.New()
+	for _, v := range sorted {
+		fmt.Fprintf(hash, "%s\x00%t\x00%d\x00", v.FileName, v.IsBinary, len(v.FileData))
+		hash.Write(v.FileData)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// 構文木をテンプレート名順に並べる
+func sortTrees(trees map[string]*parse.Tree) []*parse.Tree {
+	var result []*parse.Tree
+	for _, tree := range trees {
+		result = append(result, tree)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// store : 使用中のスナップショットを管理する構造体。Copy したレンダーオブジェクトと共有する
+type store struct {
+	mu        sync.Mutex
+	value     atomic.Value                   // 使用中の *snapshot
+	loader    func() ([]*common.File, error) // レンダーファイルの再読み込み関数
+	delims    func(string) common.Delims     // ファイル名毎の区切り文字
+	messages  fs.FS                          // メッセージカタログの読み込み元
+	fragments *common.Fragments              // cache 関数で保持する解析結果
+	stop      chan struct{}
+	once      sync.Once
+}
+
+// ファイルリスト一覧とメッセージカタログから、スナップショットを作成する
+func (s *store) create(list []*common.File) *snapshot {
+	snap := newSnapshot(list, s.delims)
+	catalogs, err := common.LoadCatalogs(s.messages)
+	if err != nil && snap.parseerr == nil {
+		snap.parseerr = err
+	}
+	snap.catalogs = catalogs
+	// メッセージカタログの内容もバージョンに含める
+	if len(catalogs) > 0 {
+		buf, _ := json.Marshal(catalogs)
+		hash := sha256.Sum256(append([]byte(snap.version), buf...))
+		snap.version = hex.EncodeToString(hash[:])
+	}
+	return snap
+}
+
+// 使用中のスナップショットを取得する
+func (s *store) load() *snapshot {
+	return s.value.Load().(*snapshot)
+}
+
+// レンダーファイルを再読み込みし、スナップショットを入れ替える
+// 読み込み、または構文木の作成に失敗した場合は、使用中のスナップショットをそのまま使用する
+func (s *store) reload() error {
+	if s.loader == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list, err := s.loader()
+	if err != nil {
+		return err
+	}
+	snap := s.create(list)
+	if snap.parseerr != nil {
+		return snap.parseerr
+	}
+	s.value.Store(snap)
+	// 再読み込み前のテンプレートの解析結果は使用しない
+	s.fragments.Purge()
+	return nil
+}
+
+// fsys 配下、及びメッセージカタログのファイルの追加、変更、削除を interval 毎に確認し、変更があった場合は再読み込みする
+// 再読み込みの結果は、callback へ通知する
+func (s *store) watch(fsys fs.FS, interval time.Duration, callback func(error)) {
+	s.stop = make(chan struct{})
+	last, _ := common.Fingerprint(fsys, s.messages)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				fingerprint, err := common.Fingerprint(fsys, s.messages)
+				// 変更がない場合は何もしない
+				if err == nil && fingerprint == last {
+					continue
+				}
+				last = fingerprint
+				if err == nil {
+					err = s.reload()
+				}
+				if callback != nil {
+					callback(err)
+				}
+			}
+		}
+	}()
+}
+
+// ファイルの監視を停止する
+func (s *store) close() {
+	s.once.Do(func() {
+		if s.stop != nil {
+			close(s.stop)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IsPartial : import, partial, layout, cache で読み込む部品のファイルか確認する
+// g で import, partial, layout, cache の参照先となっているファイルは、部品のファイルとして扱う
+// 参照先を解決できない import 等で読み込むファイルは、ファイル名、またはディレクトリ名を "_" で始めることで、部品のファイルとして扱う
+func IsPartial(g *Graph, name string) bool {
+	var edges []Edge
+	if g != nil {
+		edges = g.usedby[name]
+	}
+	for _, edge := range edges {
+		switch edge.Kind {
+		case "import", "partial", "layout", "cache":
+			return true
+		}
+	}
+	for _, v := range strings.Split(path.Clean(name), "/") {
+		if strings.HasPrefix(v, "_") {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteFile : data を一時ファイルへ書き込んだ後に、fname へ置き換える
+// fname の内容のハッシュ値が data と同じ場合は、書き込まずに false を返却する
+func WriteFile(fname string, data []byte) (bool, error) {
+	if old, err := os.ReadFile(fname); err == nil {
+		a, b := sha256.Sum256(old), sha256.Sum256(data)
+		if bytes.Equal(a[:], b[:]) {
+			return false, nil
+		}
+	}
+	dir := filepath.Dir(fname)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(fname)+".*")
+	if err != nil {
+		return false, err
+	}
+	// 置き換えに失敗した場合は、一時ファイルを削除する
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), fname); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -2,14 +2,19 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
-	"os"
+	"io/fs"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
 	"text/template"
+	"text/template/parse"
+	"time"
 
 	"github.com/ochipin/render/core"
 )
@@ -23,21 +28,58 @@ const (
 	HelperSmall = 3
 )
 
+// Reserved : レンダーが組み込みで提供する関数名。ヘルパ関数名としては使用できない
+var Reserved = []string{"import", "hastemplate", "layout", "partial", "dict", "list", "cache"}
+
+// MessageFuncs : メッセージカタログを使用する関数名。Messages を指定した場合のみ提供するが、ヘルパ関数名としては使用できない
+var MessageFuncs = []string{"t", "tn"}
+
 // File : 読み込んだファイルの情報を管理する構造体
 type File struct {
-	FileData []byte // ファイルデータ
-	FileName string // ファイル名
-	IsBinary bool   // バイナリデータの場合は true が格納される
+	FileData []byte            // ファイルデータ
+	FileName string            // ファイル名
+	Origin   string            // ファイルを読み込んだディレクトリ
+	ModTime  time.Time         // ファイルの更新日時
+	IsBinary bool              // バイナリデータの場合は true が格納される
+	Encoded  map[string][]byte // エンコーディング毎の圧縮データ
 }
 
 // Config : レンダー情報の設定状況を受け取るための構造体
 type Config struct {
 	Directory string
+	FS        fs.FS
 	Targets   []string
 	Exclude   *regexp.Regexp
+	Escape    []string
 	Binary    bool
 	MaxSize   int64
+	Delims    Delims            // テンプレートの区切り文字
+	ExtDelims map[string]Delims // 拡張子毎のテンプレートの区切り文字
 	Files     []*File
+	Loader    func() ([]*File, error) // Files の再読み込み関数(Cache = true の時のみ有効)
+	Watch     time.Duration           // ファイルの監視間隔(Cache = true の時のみ有効)
+	OnReload  func(error)             // 監視による再読み込み結果の通知先
+	Messages  fs.FS                   // メッセージカタログの読み込み元(nil = t, tn を使用しない)
+	Locale    string                  // ロケール未指定時、及びメッセージが見つからない場合に使用するロケール
+	Fragments int                     // cache 関数で保持する解析結果の最大件数(0 = FragmentSize)
+}
+
+// Delims : テンプレートの区切り文字。空文字の場合は、"{{", "}}" を使用する
+type Delims struct {
+	Left  string
+	Right string
+}
+
+// DelimsOf : 指定したファイル名に使用する区切り文字を取得する
+// 拡張子毎の区切り文字に一致する拡張子がある場合は、最も長く一致した拡張子の区切り文字を使用する
+func DelimsOf(fname string, delims Delims, ext map[string]Delims) Delims {
+	var match string
+	for k, v := range ext {
+		if strings.HasSuffix(fname, k) && len(k) > len(match) {
+			match, delims = k, v
+		}
+	}
+	return delims
 }
 
 // HasSuffix : 指定されている拡張子と、fnameに格納されている拡張子が一致するか確認する
@@ -127,12 +169,18 @@ func Helpers(funcs template.FuncMap, i interface{}, HelperType int) error {
 		}
 	}
 
-	// メソッドを登録する
+	// 登録する関数名を取得する
+	var names = make(map[string]interface{})
 	switch HelperType {
 	// 構造体型として登録
 	case HelperStruct:
 		// Helper{} => Helper.MethodName でコール可能
-		funcs[val.Type().Name()] = func() interface{} {
+		// ポインタが渡されている場合は、ポインタが指す構造体名で登録する
+		typ := val.Type()
+		for typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		names[typ.Name()] = func() interface{} {
 			return val.Interface()
 		}
 	// 構造体のメソッド名の大文字で登録
@@ -140,24 +188,53 @@ func Helpers(funcs template.FuncMap, i interface{}, HelperType int) error {
 		// Helper{} => MethodName でコール可能
 		for i := 0; i < val.Type().NumMethod(); i++ {
 			method := val.Type().Method(i)
-			funcs[method.Name] = val.Method(i).Interface()
+			names[method.Name] = val.Method(i).Interface()
 		}
 	// 構造体のメソッド名の小文字で登録
 	case HelperSmall:
 		// Helper{} => methodname でコール可能
 		for i := 0; i < val.Type().NumMethod(); i++ {
 			method := val.Type().Method(i)
-			funcs[strings.ToLower(method.Name)] = val.Method(i).Interface()
+			names[strings.ToLower(method.Name)] = val.Method(i).Interface()
 		}
 	}
+	// 組み込みの関数名と同じ名前がある場合は、1つも登録せずにエラーを返却する
+	for k, fn := range names {
+		if err := checkReserved(k, fn); err != nil {
+			return err
+		}
+	}
+	for k, fn := range names {
+		funcs[k] = fn
+	}
 
 	return nil
 }
 
+// 組み込みの関数名と同じ名前の場合は、HelperInvalid を返却する
+func checkReserved(name string, fn interface{}) error {
+	for _, list := range [][]string{Reserved, MessageFuncs, {Checkpoint}} {
+		for _, v := range list {
+			if name == v {
+				return &core.HelperInvalid{
+					Message: fmt.Sprintf("'%s' function already exists", name),
+					Type:    fmt.Sprintf("%T", fn),
+					Kind:    reflect.ValueOf(fn).Kind().String(),
+				}
+			}
+		}
+	}
+	return nil
+}
+
 // FuncMapHelper : template.FuncMap 型でヘルパを登録する
 func FuncMapHelper(addfuncs, basefuncs template.FuncMap) error {
 	// これから登録する template.FuncMap 型に登録されているメソッド群をループで処理
 	for k, v := range addfuncs {
+		// 組み込みの関数名と同じ名前の場合、エラーを返却する
+		if err := checkReserved(k, v); err != nil {
+			return err
+		}
 		val := reflect.ValueOf(v)
 		// 登録データが nil の場合、エラーを返却する
 		if val.IsValid() == false {
@@ -226,51 +303,87 @@ func CheckFuncName(renderfuncs template.FuncMap) (template.FuncMap, error) {
 	for name, fn := range renderfuncs {
 		// 不正な名前で登録されていた場合は、エラーとして扱う
 		if funcname.MatchString(name) == false {
-			return nil, fmt.Errorf("function name %s is not a valid identifier", name)
+			return nil, &core.HelperInvalid{
+				Message: fmt.Sprintf("function name %s is not a valid identifier", name),
+				Type:    fmt.Sprintf("%T", fn),
+				Kind:    reflect.ValueOf(fn).Kind().String(),
+			}
 		}
-		// import, hastemplate という名前の場合は、エラーとして扱う
-		if name == "import" || name == "hastemplate" {
-			return nil, fmt.Errorf("'%s' function already exists", name)
+		// import, hastemplate 等の組み込み関数と同じ名前の場合は、エラーとして扱う
+		if err := checkReserved(name, fn); err != nil {
+			return nil, err
 		}
 		funcs[name] = fn
 	}
 	return funcs, nil
 }
 
-// Import : 指定されたテンプレート名でテンプレートファイルを解析する
-func Import(tmpl *template.Template, data interface{}, format string, i ...interface{}) (string, error) {
-	// テンプレート名を変数へ格納
-	var tmplname = format
+// TemplateName : import, hastemplate に指定された format から、テンプレート名を作成する
+func TemplateName(format string, i ...interface{}) string {
 	if len(i) >= 1 {
-		tmplname = fmt.Sprintf(format, i...)
+		return fmt.Sprintf(format, i...)
+	}
+	return format
+}
+
+// Partial : 指定されたテンプレート名のテンプレートを、指定したデータで解析する
+func Partial(ctx context.Context, tmpl Template, tmplname string, data interface{}) (string, error) {
+	// コンテキストがキャンセルされている場合は、解析を行わない
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
 	// テンプレート名から、該当するテンプレートファイルをロードする
 	var buf bytes.Buffer
-	if err := tmpl.ExecuteTemplate(&buf, tmplname, data); err != nil {
+	if err := tmpl.ExecuteTemplate(ContextWriter(ctx, &buf), tmplname, data); err != nil {
 		return "", err
 	}
 	// テンプレートファイルの内容を返却する
 	return buf.String(), nil
 }
 
-// HasTemplate : 指定されたテンプレート名でテンプレートファイルが存在するかチェックする
-func HasTemplate(tmpl *template.Template, format string, i ...interface{}) bool {
-	// テンプレート名を変数へ格納
-	var tmplname = format
-	if len(i) >= 1 {
-		tmplname = fmt.Sprintf(format, i...)
+// Parse : テンプレート文字列を解析し、定義されている全テンプレートの構文木を返却する
+// ヘルパ関数の存在チェックは行わず、実行時に判定する
+func Parse(name, text string, delims Delims) (map[string]*parse.Tree, error) {
+	var trees = make(map[string]*parse.Tree)
+	tree := parse.New(name)
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(text, delims.Left, delims.Right, trees); err != nil {
+		return nil, err
 	}
-	return tmpl.Lookup(tmplname) != nil
+	return trees, nil
 }
 
-// Template : テンプレート解析結果を返却する
-func Template(tmpl *template.Template, tmplname string, exclude *regexp.Regexp, data interface{}) ([]byte, error) {
+// Execute : テンプレート解析結果を返却する
+func Execute(tmpl Template, tmplname string, exclude *regexp.Regexp, data interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	// テンプレートファイルの解析結果をバッファへ保持
-	if err := tmpl.ExecuteTemplate(&buf, tmplname, data); err != nil {
-		return nil, RenderError(err, tmpl, "")
+	if err := ExecuteTo(context.Background(), &buf, tmpl, tmplname, exclude, data); err != nil {
+		return nil, err
 	}
-	return Exclude(buf.String(), exclude), nil
+	return buf.Bytes(), nil
+}
+
+// ExecuteTo : テンプレート解析結果を w へ書き込む
+// 文字列除外が設定されている場合は、解析結果全体に除外処理を行う必要があるため、一旦バッファへ保持してから書き込む
+// コンテキストがキャンセルされた場合は、アクションの間で解析を中断する
+func ExecuteTo(ctx context.Context, w io.Writer, tmpl Template, tmplname string, exclude *regexp.Regexp, data interface{}) error {
+	// コンテキストがキャンセルされている場合は、解析を行わない
+	if err := ctx.Err(); err != nil {
+		return RenderError(err, tmpl, "")
+	}
+	// 文字列除外が未設定の場合は、解析結果を直接 w へ書き込む
+	if exclude == nil {
+		if err := tmpl.ExecuteTemplate(ContextWriter(ctx, w), tmplname, data); err != nil {
+			return RenderError(err, tmpl, "")
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(ContextWriter(ctx, &buf), tmplname, data); err != nil {
+		return RenderError(err, tmpl, "")
+	}
+	_, err := w.Write(Exclude(buf.String(), exclude))
+	return err
 }
 
 // Exclude : 指定した正規表現で文字列除外を行う
@@ -302,17 +415,34 @@ func Exclude(text string, regex *regexp.Regexp) []byte {
 }
 
 // RenderError : レンダーエラー発生時に、エラー内容を生成する
-func RenderError(err error, tmpl *template.Template, root string) error {
-	var result = &core.RenderError{Message: err.Error()}
+func RenderError(err error, tmpl Template, root string) error {
+	// コンテキストのキャンセル、タイムアウトの場合は、ContextError を返却する
+	if ctxerr, ok := ContextError(err); ok {
+		return ctxerr
+	}
+	var result = &core.RenderError{Message: err.Error(), Category: core.ErrExec, Err: err}
+	// テンプレートセットが指定されていない場合は、パースエラーとする
+	if tmpl == nil {
+		result.Category = core.ErrParse
+	}
 
 	// エラー内容を分割する
 	// ex) template: app/index.html:10:28: executing ...
-	fields := strings.Fields(err.Error())
+	// html/template のエラーは、html/template:app/index.html:10:28: ... となるため、形式を揃える
+	message := err.Error()
+	if strings.HasPrefix(message, "html/template:") {
+		message = "template: " + message[len("html/template:"):]
+	}
+	fields := strings.Fields(message)
 	// 分割したエラー内容から、2フィールド目の app/index.html:10:28: の部分を取得する
 	if len(fields) > 1 {
 		// 2番目のカラムに":"が存在していない場合は、TemplateErrorとする
 		if strings.Index(fields[1], ":") == -1 {
-			return &core.TemplateError{Message: err.Error()}
+			target, ok := MissingTemplate(err)
+			if !ok {
+				return &core.TemplateError{Message: err.Error(), Category: result.Category, Err: err}
+			}
+			return &core.TemplateError{Message: err.Error(), Name: target, Category: core.ErrNotFound, Err: err}
 		}
 		fields = strings.Fields(strings.Replace(fields[1], ":", " ", -1))
 	}
@@ -329,55 +459,197 @@ func RenderError(err error, tmpl *template.Template, root string) error {
 	}
 
 	// エラー発生箇所を格納する
-	if tmpl != nil && tmpl.Lookup(result.Basename) != nil {
+	if tree := lookup(tmpl, result.Basename); tree != nil {
 		// ExecuteTemplate エラー時はtemplateを利用
-		result.Root = tmpl.Lookup(result.Basename).Root.String()
+		result.Root = tree.Root.String()
 	} else {
 		// Parse エラー時は引数のrootを利用
 		result.Root = root
 	}
 
-	// import, template による読み込みに失敗した場合
-	r := regexp.MustCompile(`.+error calling .+: .+: no template \"([^"]*?)\" .+`)
-	if r.MatchString(err.Error()) {
-		result.Target = r.ReplaceAllString(err.Error(), "$1")
+	// import, template による読み込みに失敗した場合、または存在しないテンプレートファイルを指定された場合
+	if target, ok := MissingTemplate(err); ok {
+		result.Target = target
 	}
 
-	// 存在しないテンプレートファイルを指定された場合
-	r = regexp.MustCompile(`.+at .+: template \"([^"]*?)\" not defined.*`)
-	if r.MatchString(err.Error()) {
-		result.Target = r.ReplaceAllString(err.Error(), "$1")
+	// エラー発生箇所までに読み込んだテンプレートと、パースエラー時はエラー発生箇所の前後の行を格納する
+	result.Stack = Frames(err)
+	if n := len(result.Stack); n > 0 && tmpl == nil && root != "" {
+		result.Excerpt = Excerpt(root, result.Stack[n-1].Line, ExcerptLines)
+	}
+
+	return result
+}
+
+// エラー内容から、テンプレート名、行番号、カラム番号を抽出するための正規表現
+var locations = regexp.MustCompile(`(?:^|\s|/)template: ?([^\s:"]+):(\d+)(?::(\d+))?:`)
+
+// Frames : エラー内容から、エラー発生箇所までに読み込んだテンプレートの位置を、外側から順に取得する
+// import 先でエラーが発生した場合、エラー内容には import 元から順に位置が含まれる
+func Frames(err error) []core.Frame {
+	var frames []core.Frame
+	for _, m := range locations.FindAllStringSubmatch(err.Error(), -1) {
+		var frame = core.Frame{Name: m[1]}
+		frame.Line, _ = strconv.Atoi(m[2])
+		frame.Column, _ = strconv.Atoi(m[3])
+		frames = append(frames, frame)
 	}
+	return frames
+}
+
+// ExcerptLines : エラー発生箇所の前後に表示する行数
+const ExcerptLines = 2
 
+// Excerpt : text から、指定した行の前後 n 行を取得する
+func Excerpt(text string, line, n int) []core.SourceLine {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if line < 1 || line > len(lines) {
+		return nil
+	}
+	var result []core.SourceLine
+	for i := line - n; i <= line+n; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		result = append(result, core.SourceLine{Number: i, Text: strings.TrimSuffix(lines[i-1], "\r")})
+	}
 	return result
 }
 
-// ReadFile : 指定されたファイルを読み込む
-func ReadFile(fname string) (*Buf, error) {
-	// 指定されたファイルを読み込む
-	fp, err := os.Open(fname)
-	// ファイルが存在しない、またはパーミッションがない場合はエラーとする
+// Diagnose : RenderError のエラー元のレンダーファイル本文を、source で取得した元のファイル内容へ置き換え、
+// 最も内側のエラー発生箇所の前後の行を格納する。RenderError 以外のエラーは、そのまま返却する
+func Diagnose(err error, source func(string) (string, bool)) error {
+	rerr, ok := err.(*core.RenderError)
+	if !ok || rerr.Excerpt != nil {
+		return err
+	}
+	if text, ok := source(rerr.Basename); ok {
+		rerr.Root = text
+	}
+	if n := len(rerr.Stack); n > 0 {
+		if text, ok := source(rerr.Stack[n-1].Name); ok {
+			rerr.Excerpt = Excerpt(text, rerr.Stack[n-1].Line, ExcerptLines)
+		}
+	}
+	return rerr
+}
+
+// 存在しないテンプレート名を抽出するための正規表現
+var missingTemplates = []*regexp.Regexp{
+	// text/template
+	regexp.MustCompile(`no template "([^"]*?)" associated`),
+	regexp.MustCompile(`template "([^"]*?)" not defined`),
+	// html/template
+	regexp.MustCompile(`no such template "([^"]*?)"`),
+	regexp.MustCompile(`html/template: "([^"]*?)" is undefined`),
+}
+
+// MissingTemplate : エラー内容から、存在しないテンプレート名を取得する
+func MissingTemplate(err error) (string, bool) {
+	for _, r := range missingTemplates {
+		// import 先でエラーが発生した場合を考慮し、最も内側のテンプレート名を取得する
+		if m := r.FindAllStringSubmatch(err.Error(), -1); len(m) > 0 {
+			return m[len(m)-1][1], true
+		}
+	}
+	return "", false
+}
+
+// テンプレートセットから、指定した名前の構文木を取得する
+func lookup(tmpl Template, name string) *parse.Tree {
+	if tmpl == nil {
+		return nil
+	}
+	return tmpl.Lookup(name)
+}
+
+// Fingerprint : fs.FS 配下の全ファイルのパス、サイズ、更新日時から、変更検知用の値を作成する
+// nil を指定した fs.FS は無視する
+func Fingerprint(fsys ...fs.FS) (string, error) {
+	var hash = sha256.New()
+	for i, v := range fsys {
+		if v == nil {
+			continue
+		}
+		fmt.Fprintf(hash, "%d\n", i)
+		if err := fingerprint(hash, v); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// fs.FS 配下の全ファイルのパス、サイズ、更新日時を w へ書き込む
+func fingerprint(w io.Writer, fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\n", path, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+}
+
+// ETag : ファイルの内容から ETag を作成する
+func ETag(data []byte) string {
+	hash := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// WeakETag : ファイルの更新日時とサイズから、弱い ETag を作成する
+func WeakETag(modtime time.Time, size int64) string {
+	return fmt.Sprintf(`W/"%x-%x"`, modtime.UnixNano(), size)
+}
+
+// OpenFile : fs.FS から指定されたファイルを読み込む
+func OpenFile(fsys fs.FS, fname string) (*Buf, error) {
+	fp, err := fsys.Open(fname)
 	if err != nil {
 		return nil, err
 	}
-	return &Buf{fp}, nil
+	// シークできないファイルの場合は、全データをメモリ上へ読み込む
+	data, ok := fp.(io.ReadSeeker)
+	if !ok {
+		buf, err := io.ReadAll(fp)
+		if err != nil {
+			fp.Close()
+			return nil, err
+		}
+		data = bytes.NewReader(buf)
+	}
+	return &Buf{fp, data}, nil
 }
 
-// Buf : ReadFileで指定したファイルポインタを管理する構造体
+// Buf : OpenFileで指定したファイルポインタを管理する構造体
 type Buf struct {
-	file *os.File
+	file fs.File
+	data io.ReadSeeker
 }
 
 // ReadAll : 全データを取得する
 func (b *Buf) ReadAll() []byte {
 	// オフセット位置を最初に戻す
-	b.file.Seek(0, os.SEEK_SET)
+	b.data.Seek(0, io.SeekStart)
 	// 全データをバッファへコピーする
 	buf := make([]byte, b.Size())
-	io.ReadFull(b.file, buf)
+	io.ReadFull(b.data, buf)
 	return buf
 }
 
+// WriteTo : 全データを w へ書き込む
+func (b *Buf) WriteTo(w io.Writer) (int64, error) {
+	// オフセット位置を最初に戻す
+	b.data.Seek(0, io.SeekStart)
+	return io.Copy(w, b.data)
+}
+
 // IsBinary : バイナリデータか否かを判定する
 func (b *Buf) IsBinary() bool {
 	// ファイルサイズに応じて、読み込むバッファサイズを変更する
@@ -388,9 +660,9 @@ func (b *Buf) IsBinary() bool {
 	var buf = make([]byte, size)
 
 	// オフセット位置を最初に戻す
-	b.file.Seek(0, os.SEEK_SET)
+	b.data.Seek(0, io.SeekStart)
 	// ファイルの内容の一部をバッファへコピー
-	reader := io.LimitReader(b.file, size)
+	reader := io.LimitReader(b.data, size)
 	reader.Read(buf)
 
 	// コピーされたデータが、バイナリファイルか否かを判定する
@@ -402,6 +674,15 @@ func (b *Buf) IsBinary() bool {
 	return false
 }
 
+// ModTime : ファイルの更新日時を返却する
+func (b *Buf) ModTime() time.Time {
+	f, err := b.file.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+	return f.ModTime()
+}
+
 // Size : ファイルサイズを返却する
 func (b *Buf) Size() int64 {
 	f, _ := b.file.Stat()
@@ -2,10 +2,19 @@ package common
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"text/template"
+	"time"
+
+	"github.com/ochipin/render/core"
 )
 
 type HelperErrors1 struct{}
@@ -15,6 +24,17 @@ func (h HelperErrors1) Case1() (int, int) {
 	return 0, 0
 }
 
+type HelperReserved struct{}
+
+// メソッド名を小文字に変えた場合、t 関数と同じ名前となるためエラー
+func (h HelperReserved) T() string {
+	return "T"
+}
+
+func (h HelperReserved) Name() string {
+	return "Name"
+}
+
 type HelperErrors2 struct{}
 
 // 復帰値が、ないためエラー
@@ -30,6 +50,15 @@ func (h HelperTest) TestCase1() string {
 	return h.str
 }
 
+type HelperPointer struct {
+	str string
+}
+
+// ポインタレシーバのメソッド
+func (h *HelperPointer) TestCase2() string {
+	return h.str
+}
+
 // 登録したヘルパ関数が実行可であるかをチェックする
 func isSuccessHelperStruct(funcs template.FuncMap) (string, error) {
 	// 登録した関数で、テンプレートを解析
@@ -164,6 +193,34 @@ func Test_HELPER_SUCCESS_CASE(t *testing.T) {
 	}
 }
 
+// ポインタを渡した場合は、ポインタが指す構造体名で登録される
+func Test_HELPER_POINTER(t *testing.T) {
+	funcs := make(template.FuncMap)
+	if err := Helpers(funcs, &HelperTest{str: "Sample"}, HelperStruct); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := isSuccessHelperStruct(funcs); err != nil || result != "Sample" {
+		t.Fatal(result, err)
+	}
+	if _, ok := funcs[""]; ok || len(funcs) != 1 {
+		t.Fatal(funcs)
+	}
+
+	// ポインタレシーバのメソッドも、構造体名.メソッド名でコール可能
+	funcs = make(template.FuncMap)
+	if err := Helpers(funcs, &HelperPointer{str: "Pointer"}, HelperStruct); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := template.New("test").Funcs(funcs).Parse(`{{HelperPointer.TestCase2}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil || buf.String() != "Pointer" {
+		t.Fatal(buf.String(), err)
+	}
+}
+
 // ヘルパ登録失敗例
 func Test_HELPER_ERROR_CASE(t *testing.T) {
 	funcs := make(template.FuncMap)
@@ -193,6 +250,15 @@ func Test_HELPER_ERROR_CASE(t *testing.T) {
 
 	Helpers(funcs, HelperErrors1{}, HelperSmall)
 	Helpers(funcs, HelperErrors2{}, HelperSmall)
+
+	// 組み込みの関数名と同じ名前になるメソッドがある場合は、1つも登録しない
+	funcs = make(template.FuncMap)
+	if err := Helpers(funcs, HelperReserved{}, HelperSmall); !errors.Is(err, core.ErrHelper) || len(funcs) != 0 {
+		t.Fatal(funcs, err)
+	}
+	if err := Helpers(funcs, HelperReserved{}, HelperLarge); err != nil || len(funcs) != 2 {
+		t.Fatal(funcs, err)
+	}
 }
 
 // ヘルパ登録の名前が不正な場合、エラーとしてみなす
@@ -252,6 +318,16 @@ func Test_ADD_HELPERS(t *testing.T) {
 		t.Fatal("Error")
 	}
 
+	// 組み込みの関数名、メッセージ関数名と同じ名前の場合、エラーとなる
+	for _, name := range []string{"import", "tn", Checkpoint} {
+		if err := FuncMapHelper(template.FuncMap{name: func() string { return "" }}, basefuncs); !errors.Is(err, core.ErrHelper) {
+			t.Fatal(name, err)
+		}
+		if _, ok := basefuncs[name]; ok {
+			t.Fatal(name)
+		}
+	}
+
 	// addfuncs に nil が含まれていた場合、エラーとなる
 	addfuncs["name"] = nil
 	if err := FuncMapHelper(addfuncs, basefuncs); err == nil {
@@ -284,11 +360,11 @@ func StringTemplate1(data interface{}) *template.Template {
 	funcs := make(template.FuncMap)
 	// import : format で指定したテンプレート名を元に、テンプレートファイルの内容をロードする
 	funcs["import"] = func(format string, i ...interface{}) (string, error) {
-		return Import(tmpl, data, format, i...)
+		return Partial(context.Background(), &textTemplate{tmpl}, TemplateName(format, i...), data)
 	}
 	// hastemplate : 指定したテンプレート名が存在するかチェックする
 	funcs["hastemplate"] = func(format string, i ...interface{}) bool {
-		return HasTemplate(tmpl, format, i...)
+		return tmpl.Lookup(TemplateName(format, i...)) != nil
 	}
 	tmpl, _ = template.New("app/sample1.html").Funcs(funcs).Parse(`{{import "%s/sample2.html" "app"}}`)
 	tmpl, _ = tmpl.New("app/sample2.html").Parse(`{{if hastemplate "%s/sample3.html" "app"}}{{import "app/sample3.html"}}{{end}}`)
@@ -301,11 +377,11 @@ func StringTemplate2(data interface{}) *template.Template {
 	funcs := make(template.FuncMap)
 	// import : format で指定したテンプレート名を元に、テンプレートファイルの内容をロードする
 	funcs["import"] = func(format string, i ...interface{}) (string, error) {
-		return Import(tmpl, data, format, i...)
+		return Partial(context.Background(), &textTemplate{tmpl}, TemplateName(format, i...), data)
 	}
 	// hastemplate : 指定したテンプレート名が存在するかチェックする
 	funcs["hastemplate"] = func(format string, i ...interface{}) bool {
-		return HasTemplate(tmpl, format, i...)
+		return tmpl.Lookup(TemplateName(format, i...)) != nil
 	}
 	// app/sample4.html は存在しないが、ロードを実施する
 	tmpl, _ = template.New("app/sample1.html").Funcs(funcs).Parse(`{{import "%s/sample4.html" "app"}}`)
@@ -320,11 +396,11 @@ func StringTemplate3(data interface{}) *template.Template {
 	funcs := make(template.FuncMap)
 	// import : format で指定したテンプレート名を元に、テンプレートファイルの内容をロードする
 	funcs["import"] = func(format string, i ...interface{}) (string, error) {
-		return Import(tmpl, data, format, i...)
+		return Partial(context.Background(), &textTemplate{tmpl}, TemplateName(format, i...), data)
 	}
 	// hastemplate : 指定したテンプレート名が存在するかチェックする
 	funcs["hastemplate"] = func(format string, i ...interface{}) bool {
-		return HasTemplate(tmpl, format, i...)
+		return tmpl.Lookup(TemplateName(format, i...)) != nil
 	}
 	// app/sample4.html は存在しないが、ロードを実施する
 	tmpl, _ = template.New("app/sample1.html").Funcs(funcs).Parse(`{{template "app/sample4.html" .}}`)
@@ -339,7 +415,7 @@ func Test_TEMPLATE_SUCCESS(t *testing.T) {
 	tmpl := StringTemplate1(data)
 	exclude := regexp.MustCompile(`(^|[|\n])//=\s*(.+?)\s*$|(^|[|\n])/\*=\s*([\s\S]+?)\s*\*/`)
 	// データ app/sample1.html を表示
-	buf, err := Template(tmpl, "app/sample1.html", exclude, nil)
+	buf, err := Execute(&textTemplate{tmpl}, "app/sample1.html", exclude, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -349,7 +425,7 @@ func Test_TEMPLATE_SUCCESS(t *testing.T) {
 	}
 
 	// データ app/sample1.html を表示
-	buf, err = Template(tmpl, "app/sample1.html", nil, nil)
+	buf, err = Execute(&textTemplate{tmpl}, "app/sample1.html", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -381,14 +457,14 @@ func Test_TEMPLATE_ERROR(t *testing.T) {
 	// template: app/index.html:1:11: executing "app/index.html" at <{{template "index/ap...>: template "index/app.html" not defined
 	tmpl, _ := template.New("app/index.html").Parse(`{{template "index/app.html" .}}`)
 	err = tmpl.ExecuteTemplate(&buf, "app/index.html", nil)
-	RenderError(err, tmpl, "")
+	RenderError(err, &textTemplate{tmpl}, "")
 
 	// template: app/index.html:1:2: executing "app/index.html" at <name>: error calling name: error message
 	tmpl, _ = template.New("app/index.html").Funcs(template.FuncMap{
 		"name": func() (int, error) { return 0, fmt.Errorf("error message") },
 	}).Parse("{{name}}")
 	err = tmpl.ExecuteTemplate(&buf, "app/index.html", nil)
-	RenderError(err, tmpl, "")
+	RenderError(err, &textTemplate{tmpl}, "")
 
 	// template: app/index.html:1:2: executing "app/index.html" at <import>: error calling import: template: no template "sample2.html" associated with template "app/index.html"
 	tmpl, _ = template.New("sample").Funcs(template.FuncMap{
@@ -401,18 +477,18 @@ func Test_TEMPLATE_ERROR(t *testing.T) {
 	tmpl, _ = tmpl.New("app/index.html").Parse(`{{import}}`)
 	err = tmpl.ExecuteTemplate(&buf, "sample", nil)
 	// fmt.Println(tmpl.Lookup("app/index.html").Root)
-	RenderError(err, tmpl, "")
+	RenderError(err, &textTemplate{tmpl}, "")
 
 	// template: sample:1:11: executing "sample" at <{{template "app/inde...>: exceeded maximum template depth (100000)
 	tmpl, _ = template.New("sample").Parse(`{{template "app/index.html" .}}`)
 	tmpl, _ = tmpl.New("app/index.html").Parse(`{{template "sample" .}}`)
 	err = tmpl.ExecuteTemplate(&buf, "sample", nil)
-	RenderError(err, tmpl, "")
+	RenderError(err, &textTemplate{tmpl}, "")
 
 	// template: no template "undefined" associated with template "app/index.html"
 	tmpl, _ = template.New("app/index.html").Parse(`{{template "sample.html" .}}`)
 	err = tmpl.ExecuteTemplate(&buf, "undefined", nil)
-	if RenderError(err, tmpl, "").Error() != `template: no template "undefined" associated with template "app/index.html"` {
+	if RenderError(err, &textTemplate{tmpl}, "").Error() != `template: no template "undefined" associated with template "app/index.html"` {
 		t.Fatal("Error")
 	}
 }
@@ -424,7 +500,7 @@ func Test_TEMPLATE_ERROR2(t *testing.T) {
 	tmpl := StringTemplate3(data)
 	exclude := regexp.MustCompile(`(^|[|\n])//=\s*(.+?)\s*$|(^|[|\n])/\*=\s*([\s\S]+?)\s*\*/`)
 	// データ app/sample1.html を表示
-	_, err := Template(tmpl, "app/sample1.html", exclude, nil)
+	_, err := Execute(&textTemplate{tmpl}, "app/sample1.html", exclude, nil)
 	if err == nil {
 		t.Fatal("Error")
 	}
@@ -432,15 +508,16 @@ func Test_TEMPLATE_ERROR2(t *testing.T) {
 	tmpl = StringTemplate2(data)
 	exclude = regexp.MustCompile(`(^|[|\n])//=\s*(.+?)\s*$|(^|[|\n])/\*=\s*([\s\S]+?)\s*\*/`)
 	// データ app/sample1.html を表示
-	_, err = Template(tmpl, "app/sample1.html", exclude, nil)
+	_, err = Execute(&textTemplate{tmpl}, "app/sample1.html", exclude, nil)
 	if err == nil {
 		t.Fatal("Error")
 	}
 }
 
-func Test_READFILE(t *testing.T) {
+func Test_OPENFILE(t *testing.T) {
+	fsys := os.DirFS(".")
 	// バイナリファイルを読み込み
-	buf, err := ReadFile("isbinary/binary.png")
+	buf, err := OpenFile(fsys, "isbinary/binary.png")
 	if err != nil {
 		t.Fatal("Error")
 	}
@@ -456,7 +533,7 @@ func Test_READFILE(t *testing.T) {
 	buf.Close()
 
 	// バイナリファイルを読み込み
-	buf, err = ReadFile("isbinary/index.html")
+	buf, err = OpenFile(fsys, "isbinary/index.html")
 	if err != nil {
 		t.Fatal("Error")
 	}
@@ -472,8 +549,242 @@ func Test_READFILE(t *testing.T) {
 	buf.Close()
 
 	// 存在しないデータを指定した場合、エラーとなる
-	buf, err = ReadFile("isbinary/undefined")
+	buf, err = OpenFile(fsys, "isbinary/undefined")
 	if err == nil {
 		t.Fatal("Error")
 	}
 }
+
+func Test_DICT_LIST(t *testing.T) {
+	m, err := Dict("a", 1, "b", "2")
+	if err != nil || m["a"] != 1 || m["b"] != "2" {
+		t.Fatal("Error")
+	}
+	// 引数の数が奇数の場合は、エラーとなる
+	if _, err := Dict("a"); err == nil {
+		t.Fatal("Error")
+	}
+	// キーが文字列ではない場合は、エラーとなる
+	if _, err := Dict(1, "a"); err == nil {
+		t.Fatal("Error")
+	}
+	if l := List(1, "a"); len(l) != 2 {
+		t.Fatal("Error")
+	}
+	// partial, dict, list はヘルパ名として使用できない
+	if _, err := CheckFuncName(template.FuncMap{"partial": List}); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func Test_DELIMS_OF(t *testing.T) {
+	var global = Delims{Left: "<%", Right: "%>"}
+	var ext = map[string]Delims{
+		".js":     {Left: "[[", Right: "]]"},
+		".min.js": {Left: "((", Right: "))"},
+	}
+	if v := DelimsOf("index.html", global, ext); v != global {
+		t.Fatal(v)
+	}
+	if v := DelimsOf("app.js", global, ext); v.Left != "[[" {
+		t.Fatal(v)
+	}
+	// 最も長く一致した拡張子の区切り文字を使用する
+	if v := DelimsOf("app.min.js", global, ext); v.Left != "((" {
+		t.Fatal(v)
+	}
+	if v := DelimsOf("index.html", Delims{}, nil); v != (Delims{}) {
+		t.Fatal(v)
+	}
+}
+
+func Test_ROOT_FS(t *testing.T) {
+	fsys := NewRootFS([]string{"app", "theme"}, []fs.FS{
+		fstest.MapFS{
+			"index.html":   &fstest.MapFile{Data: []byte(`app`)},
+			"parts/a.html": &fstest.MapFile{Data: []byte(`app`)},
+		},
+		fstest.MapFS{
+			"index.html":     &fstest.MapFile{Data: []byte(`theme`)},
+			"parts/b.html":   &fstest.MapFile{Data: []byte(`theme`)},
+			"theme/only.txt": &fstest.MapFile{Data: []byte(`theme`)},
+		},
+	})
+	// 先頭のディレクトリのファイルを優先する
+	if buf, err := fs.ReadFile(fsys, "index.html"); err != nil || string(buf) != "app" {
+		t.Fatal(string(buf), err)
+	}
+	if buf, err := fs.ReadFile(fsys, "parts/b.html"); err != nil || string(buf) != "theme" {
+		t.Fatal(string(buf), err)
+	}
+	// ディレクトリ配下のファイル一覧は、全ディレクトリを結合する
+	var names []string
+	fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			names = append(names, path)
+		}
+		return err
+	})
+	if strings.Join(names, ",") != "index.html,parts/a.html,parts/b.html,theme/only.txt" {
+		t.Fatal(names)
+	}
+	if v, err := fsys.Origin("parts/a.html"); err != nil || v != "app" {
+		t.Fatal(v, err)
+	}
+	if v, err := fsys.Origin("theme/only.txt"); err != nil || v != "theme" {
+		t.Fatal(v, err)
+	}
+	if _, err := fsys.Open("undefined.html"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	if _, err := fsys.ReadDir("undefined"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+}
+
+func Test_LOCALES(t *testing.T) {
+	if v := strings.Join(Locales("app/index.html", "zh-Hant-TW"), ","); v != "app/index.zh-Hant-TW.html,app/index.zh-Hant.html,app/index.zh.html,app/index.html" {
+		t.Fatal(v)
+	}
+	if v := strings.Join(Locales("index", "ja_JP"), ","); v != "index.ja-JP,index.ja,index" {
+		t.Fatal(v)
+	}
+	if v := strings.Join(Locales("index.html", ""), ","); v != "index.html" {
+		t.Fatal(v)
+	}
+}
+
+func Test_PLURAL_CATEGORY(t *testing.T) {
+	for _, v := range []struct {
+		lang   string
+		n      float64
+		expect string
+	}{
+		{"en", 1, "one"}, {"en", 0, "other"}, {"en", 2, "other"}, {"en", 1.5, "other"},
+		{"ja", 1, "other"}, {"fr", 0, "one"}, {"fr", 2, "other"},
+		{"ru", 1, "one"}, {"ru", 3, "few"}, {"ru", 11, "many"}, {"ru", 21, "one"}, {"ru", 25, "many"},
+		{"pl", 1, "one"}, {"pl", 22, "few"}, {"pl", 12, "many"}, {"cs", 3, "few"}, {"cs", 5, "other"},
+	} {
+		if c := PluralCategory(v.lang, v.n); c != v.expect {
+			t.Fatal(v.lang, v.n, c)
+		}
+	}
+}
+
+func Test_TRANSLATOR(t *testing.T) {
+	catalogs := map[string]string{
+		"en":    `{"hello": "Hello, {name}", "items": {"one": "{count} item", "other": "{count} items"}}`,
+		"ru":    `{"items": {"one": "{count} предмет", "few": "{count} предмета", "many": "{count} предметов", "other": "{count} предмета"}}`,
+		"ja-JP": `{"hello": "こんにちは、{name}さん"}`,
+	}
+	load := func(locale string) (Catalog, error) {
+		if v, ok := catalogs[locale]; ok {
+			return ParseCatalog(locale, []byte(v))
+		}
+		return nil, nil
+	}
+	tr := NewTranslator("ja-JP", "en", load)
+	if v, err := tr.T("hello", map[string]interface{}{"name": "太郎"}); err != nil || v != "こんにちは、太郎さん" {
+		t.Fatal(v, err)
+	}
+	if v, err := tr.TN("items", 2); err != nil || v != "2 items" {
+		t.Fatal(v, err)
+	}
+	// 置き換える値がない場合は、そのまま出力する
+	if v, err := tr.T("hello"); err != nil || v != "こんにちは、{name}さん" {
+		t.Fatal(v, err)
+	}
+	// 引数の誤り
+	if _, err := tr.T("hello", "name"); err == nil {
+		t.Fatal("Error")
+	}
+	if _, err := tr.TN("items", "2"); err == nil {
+		t.Fatal("Error")
+	}
+	tr = NewTranslator("ru", "en", load)
+	if v, err := tr.TN("items", 22); err != nil || v != "22 предмета" {
+		t.Fatal(v, err)
+	}
+	if v, err := tr.TN("items", 5); err != nil || v != "5 предметов" {
+		t.Fatal(v, err)
+	}
+	if _, err := ParseCatalog("bad.json", []byte(`[]`)); !errors.Is(err, core.ErrCatalog) {
+		t.Fatal(err)
+	}
+}
+
+func Test_FRAGMENTS(t *testing.T) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var count int
+	f := NewFragments(2)
+	f.now = func() time.Time { return now }
+	render := func() (string, error) {
+		count++
+		return fmt.Sprint(count), nil
+	}
+	get := func(key string, parts ...interface{}) string {
+		v, err := f.Get(key, parts, "nav.html", time.Minute, render)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// 有効期限内は、保持している解析結果を返却する
+	if get("nav", 1) != "1" || get("nav", 1) != "1" || get("nav", 2) != "2" {
+		t.Fatal(count)
+	}
+	// 有効期限を過ぎた場合は、再度解析する
+	now = now.Add(time.Minute)
+	if get("nav", 1) != "3" {
+		t.Fatal(count)
+	}
+	// 最大件数を超過した場合は、最も古く使用したものから削除する
+	get("menu")
+	if f.Len() != 2 || get("nav", 1) != "3" || get("nav", 2) != "5" {
+		t.Fatal(f.Len(), count)
+	}
+	// キーの要素を指定した場合は、一致するもののみ削除する
+	f.Invalidate("nav", 1)
+	if f.Len() != 1 || get("nav", 2) != "5" {
+		t.Fatal(f.Len())
+	}
+	// キーの要素を省略した場合は、キーが一致する全てを削除する
+	get("nav", 1)
+	f.Invalidate("nav")
+	if f.Len() != 0 {
+		t.Fatal(f.Len())
+	}
+	// 解析に失敗した場合は保持しない
+	if _, err := f.Get("err", nil, "", time.Minute, func() (string, error) { return "", errors.New("error") }); err == nil || f.Len() != 0 {
+		t.Fatal(err)
+	}
+	f.Get("a", nil, "", time.Minute, render)
+	f.Purge()
+	if f.Len() != 0 {
+		t.Fatal(f.Len())
+	}
+	// 有効期限の変換
+	for _, v := range []interface{}{60, 60.0, time.Minute} {
+		if d, err := TTL(v); err != nil || d != time.Minute {
+			t.Fatal(v, d, err)
+		}
+	}
+	if _, err := TTL("60"); err == nil {
+		t.Fatal("Error")
+	}
+}
+
+func Test_FRAMES(t *testing.T) {
+	err := fmt.Errorf(`template: a.html:2:5: executing "a.html" at <import "b.html">: error calling import: html/template:b.html:10: unexpected EOF`)
+	frames := Frames(err)
+	if len(frames) != 2 || frames[0].String() != "a.html:2:5" || frames[1].String() != "b.html:10" {
+		t.Fatal(frames)
+	}
+	lines := Excerpt("1\n2\n3\n4\n5\n6\n", 1, ExcerptLines)
+	if len(lines) != 3 || lines[0].Number != 1 || lines[2].Text != "3" {
+		t.Fatal(lines)
+	}
+	if lines := Excerpt("1\n", 3, ExcerptLines); lines != nil {
+		t.Fatal(lines)
+	}
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"bytes"
+	"compress/gzip"
+	"regexp"
+	"strings"
+	"text/template/parse"
+)
+
+// Gzip : データを gzip で圧縮する
+func Gzip(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Compressible : 拡張子から判定した Content-Type が、圧縮に適した形式か確認する
+func Compressible(name string, data []byte) bool {
+	ctype := ContentType(name, data)
+	if strings.HasPrefix(ctype, "text/") {
+		return true
+	}
+	for _, v := range []string{"json", "javascript", "xml", "wasm", "font/ttf", "font/otf", "vnd.ms-fontobject"} {
+		if strings.Contains(ctype, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// テンプレートのアクションを含まない、解析結果が常に同じテンプレートの場合は、構文木を返却する
+func static(name, text string, delims Delims) (*parse.Tree, bool) {
+	trees, err := Parse(name, text, delims)
+	if err != nil || len(trees) != 1 || trees[name] == nil {
+		return nil, false
+	}
+	for _, node := range trees[name].Root.Nodes {
+		if node.Type() != parse.NodeText {
+			return nil, false
+		}
+	}
+	return trees[name], true
+}
+
+// Compress : ファイルの圧縮データを、エンコーディング毎に作成する
+// テンプレートファイルはアクションを含まないもののみを対象とし、escape に応じた解析結果を文字列除外した後に圧縮する
+// アクションを含まないテンプレートは他のテンプレートを参照しないため、解析結果は Render の解析結果と同じとなる
+// 圧縮してもサイズが小さくならない場合は、作成しない
+func Compress(file *File, delims Delims, escape bool, exclude *regexp.Regexp) map[string][]byte {
+	var data = file.FileData
+	if !file.IsBinary {
+		tree, ok := static(file.FileName, string(data), delims)
+		if !ok {
+			return nil
+		}
+		// html/template の場合は、HTML コメントの除去等が行われるため、テンプレートとして解析した結果を使用する
+		tmpl := NewTemplate(escape)
+		if err := tmpl.AddParseTree(file.FileName, tree); err != nil {
+			return nil
+		}
+		var err error
+		if data, err = Execute(tmpl, file.FileName, exclude, nil); err != nil {
+			return nil
+		}
+	}
+	if !Compressible(file.FileName, data) {
+		return nil
+	}
+	gz := Gzip(data)
+	if len(gz) >= len(data) {
+		return nil
+	}
+	return map[string][]byte{"gzip": gz}
+}
@@ -0,0 +1,184 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/ochipin/render/core"
+)
+
+// context.Context の型情報
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+// ContextWriter : 書き込み毎に、コンテキストがキャンセルされていないか確認する io.Writer を返却する
+// 何も出力しないアクションの間でのキャンセルは、Interruptible で追加した確認処理で検知する
+func ContextWriter(ctx context.Context, w io.Writer) io.Writer {
+	return &contextWriter{ctx: ctx, w: w}
+}
+
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c *contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// Checkpoint : コンテキストのキャンセルを確認する関数名
+// ヘルパ関数名は英字で始まる必要があるため、ヘルパ関数名と重複しない
+const Checkpoint = "_checkpoint"
+
+// CheckContext : コンテキストがキャンセルされている場合に、エラーを返却する関数を作成する。Checkpoint の名前で登録する
+func CheckContext(ctx context.Context) func() (bool, error) {
+	return func() (bool, error) {
+		return false, ctx.Err()
+	}
+}
+
+// Interruptible : テンプレートの先頭、及び range の繰り返し毎に、コンテキストのキャンセルを確認するよう構文木を書き換える
+// 追加する確認処理は {{if _checkpoint}}{{end}} と同じで、何も出力しない
+// 必ず出力を行うテンプレート、及び range は、ContextWriter でキャンセルを検知できるため、書き換えない
+// text, delims には、trees を作成した時のテキストと区切り文字を指定する
+// 空の構文木は、同名のテンプレートを上書きしない扱いを変えないよう、書き換えない
+func Interruptible(trees map[string]*parse.Tree, text string, delims Delims) map[string]*parse.Tree {
+	var check *parse.IfNode
+	for _, tree := range trees {
+		if tree.Root == nil || parse.IsEmptyTree(tree.Root) {
+			continue
+		}
+		if check == nil {
+			if check = checkpoint(tree.ParseName, text, delims); check == nil {
+				return trees
+			}
+		}
+		Walk(tree.Root, func(node parse.Node) {
+			if node, ok := node.(*parse.RangeNode); ok && !writes(node.List) {
+				prepend(node.List, check)
+			}
+		})
+		if !writes(tree.Root) {
+			prepend(tree.Root, check)
+		}
+	}
+	return trees
+}
+
+// リストの実行時に、必ず出力を行うか確認する
+// 出力を行うノードより前に、continue, break を含む可能性があるノードがある場合は、出力を行わない場合があるとする
+func writes(list *parse.ListNode) bool {
+	for _, node := range list.Nodes {
+		switch node := node.(type) {
+		case *parse.TextNode:
+			return true
+		case *parse.ActionNode:
+			// 変数宣言は、出力を行わない
+			if len(node.Pipe.Decl) == 0 {
+				return true
+			}
+		case *parse.IfNode, *parse.WithNode, *parse.BreakNode, *parse.ContinueNode:
+			return false
+		}
+	}
+	return false
+}
+
+// 確認処理のノードを作成する
+// エラー発生箇所を元のテキストの位置で表示するため、改行以外を空白に置き換えたテキストの末尾へ追加してパースする
+func checkpoint(name, text string, delims Delims) *parse.IfNode {
+	var buf = []byte(text)
+	for i, c := range buf {
+		if c != '\n' {
+			buf[i] = ' '
+		}
+	}
+	left, right := delims.Left, delims.Right
+	if left == "" {
+		left = "{{"
+	}
+	if right == "" {
+		right = "}}"
+	}
+	trees, err := Parse(name, string(buf)+left+"if "+Checkpoint+right+left+"end"+right, delims)
+	if err != nil {
+		return nil
+	}
+	nodes := trees[name].Root.Nodes
+	check, _ := nodes[len(nodes)-1].(*parse.IfNode)
+	return check
+}
+
+// リストの先頭に、確認処理を追加する。エラー発生箇所は、リストの位置となる
+func prepend(list *parse.ListNode, check *parse.IfNode) {
+	if list == nil {
+		return
+	}
+	node := check.Copy().(*parse.IfNode)
+	cmd := node.Pipe.Cmds[0]
+	node.Pos, node.Pipe.Pos, cmd.Pos = list.Pos, list.Pos, list.Pos
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+		ident.Pos = list.Pos
+	}
+	list.Nodes = append([]parse.Node{node}, list.Nodes...)
+}
+
+// ContextFuncs : 第1引数が context.Context 型のヘルパ関数のみを抽出する
+func ContextFuncs(funcs template.FuncMap) template.FuncMap {
+	var result = make(template.FuncMap)
+	for name, fn := range funcs {
+		typ := reflect.TypeOf(fn)
+		if typ.Kind() == reflect.Func && typ.NumIn() > 0 && typ.In(0) == contextType {
+			result[name] = fn
+		}
+	}
+	return result
+}
+
+// BindContext : 第1引数が context.Context 型のヘルパ関数を、ctx を自動で渡す関数へ変換する
+func BindContext(ctx context.Context, funcs template.FuncMap) template.FuncMap {
+	var result = make(template.FuncMap)
+	for name, fn := range ContextFuncs(funcs) {
+		val := reflect.ValueOf(fn)
+		typ := val.Type()
+		// 第1引数を除いた関数型を作成する
+		var in []reflect.Type
+		for i := 1; i < typ.NumIn(); i++ {
+			in = append(in, typ.In(i))
+		}
+		var out []reflect.Type
+		for i := 0; i < typ.NumOut(); i++ {
+			out = append(out, typ.Out(i))
+		}
+		ctxval := reflect.ValueOf(ctx)
+		result[name] = reflect.MakeFunc(reflect.FuncOf(in, out, typ.IsVariadic()), func(args []reflect.Value) []reflect.Value {
+			args = append([]reflect.Value{ctxval}, args...)
+			// 可変長引数の場合、最後の引数はスライスとして渡される
+			if typ.IsVariadic() {
+				return val.CallSlice(args)
+			}
+			return val.Call(args)
+		}).Interface()
+	}
+	return result
+}
+
+// ContextError : コンテキストのキャンセル、タイムアウトが原因のエラーであれば、core.ContextError を返却する
+func ContextError(err error) (error, bool) {
+	var ctxerr error
+	switch {
+	case errors.Is(err, context.Canceled):
+		ctxerr = context.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		ctxerr = context.DeadlineExceeded
+	default:
+		return err, false
+	}
+	return &core.ContextError{Message: err.Error(), Err: ctxerr}, true
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/ochipin/render/core"
+)
+
+// NotDefined : 存在しない、または対象外のテンプレートファイルを指定した場合のエラーを生成する
+// kind には、ErrNotFound, または ErrBinaryDisabled を指定する
+func NotDefined(name string, kind error) error {
+	return &core.TemplateError{Message: "template: \"" + name + "\" not defined", Name: name, Category: kind}
+}
+
+// FileError : ファイルの読み込みに失敗した場合のエラーを生成する
+// ファイルが存在しない場合は ErrNotFound、それ以外は ErrRead となる
+func FileError(name string, err error) error {
+	var kind = core.ErrRead
+	if errors.Is(err, fs.ErrNotExist) {
+		kind = core.ErrNotFound
+	}
+	return &core.TemplateError{Message: "template: " + err.Error(), Name: name, Category: kind, Err: err}
+}
+
+// TooLarge : ファイルサイズが設定値を超過した場合のエラーを生成する
+func TooLarge(name string, maxsize, size int64) error {
+	return &core.TemplateError{
+		Message:  fmt.Sprintf("%s: %d < %d. maxsize over", name, maxsize, size),
+		Name:     name,
+		Category: core.ErrTooLarge,
+		Limit:    maxsize,
+		Size:     size,
+	}
+}
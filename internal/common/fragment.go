@@ -0,0 +1,132 @@
+package common
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FragmentSize : フラグメントキャッシュの既定の最大件数
+const FragmentSize = 1000
+
+// Fragments : テンプレートの解析結果を、キーと有効期限を指定して保持する。最大件数を超過した場合は、最も古く使用したものから削除する
+type Fragments struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List // 使用順。先頭が最も新しく使用したもの
+	now     func() time.Time
+}
+
+// キャッシュした解析結果
+type fragment struct {
+	id      string // group と variant から作成した識別子
+	group   string // キーと、キーの要素から作成した識別子
+	key     string
+	value   string
+	expires time.Time
+}
+
+// NewFragments : 最大件数を指定して Fragments を生成する。0 以下の場合は FragmentSize となる
+func NewFragments(size int) *Fragments {
+	if size <= 0 {
+		size = FragmentSize
+	}
+	return &Fragments{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+// Get : キーとキーの要素、及び variant(テンプレート名、ロケール等)に対応する、有効期限内の解析結果を取得する
+// 存在しない場合は fn で解析し、ttl の間保持する。解析に失敗した場合は保持しない
+func (f *Fragments) Get(key string, parts []interface{}, variant string, ttl time.Duration, fn func() (string, error)) (string, error) {
+	group := fragmentID(key, parts)
+	id := group + "\x00\x00" + variant
+	f.mu.Lock()
+	if e, ok := f.entries[id]; ok {
+		entry := e.Value.(*fragment)
+		if f.now().Before(entry.expires) {
+			f.order.MoveToFront(e)
+			f.mu.Unlock()
+			return entry.value, nil
+		}
+		f.remove(e)
+	}
+	f.mu.Unlock()
+
+	// 解析中はロックしない
+	value, err := fn()
+	if err != nil || ttl <= 0 {
+		return value, err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.entries[id]; ok {
+		f.remove(e)
+	}
+	f.entries[id] = f.order.PushFront(&fragment{id: id, group: group, key: key, value: value, expires: f.now().Add(ttl)})
+	for f.order.Len() > f.size {
+		f.remove(f.order.Back())
+	}
+	return value, nil
+}
+
+// Invalidate : 指定したキーの解析結果を削除する。キーの要素を省略した場合は、キーが一致する全ての解析結果を削除する
+func (f *Fragments) Invalidate(key string, parts ...interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	group := fragmentID(key, parts)
+	for _, e := range f.entries {
+		entry := e.Value.(*fragment)
+		if (len(parts) == 0 && entry.key == key) || entry.group == group {
+			f.remove(e)
+		}
+	}
+}
+
+// Purge : 全ての解析結果を削除する
+func (f *Fragments) Purge() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = make(map[string]*list.Element)
+	f.order.Init()
+}
+
+// Len : 保持している解析結果の件数を返却する
+func (f *Fragments) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.order.Len()
+}
+
+func (f *Fragments) remove(e *list.Element) {
+	f.order.Remove(e)
+	delete(f.entries, e.Value.(*fragment).id)
+}
+
+// キーと、キーの要素から識別子を作成する
+func fragmentID(key string, parts []interface{}) string {
+	var ids = []string{key}
+	for _, v := range parts {
+		ids = append(ids, fmt.Sprint(v))
+	}
+	return strings.Join(ids, "\x00")
+}
+
+// TTL : cache 関数に指定された有効期限を time.Duration へ変換する。数値の場合は秒として扱う
+func TTL(ttl interface{}) (time.Duration, error) {
+	if v, ok := ttl.(time.Duration); ok {
+		return v, nil
+	}
+	n, err := toFloat(ttl)
+	if err != nil {
+		return 0, fmt.Errorf("cache: ttl must be a number of seconds or time.Duration, got %T", ttl)
+	}
+	return time.Duration(n * float64(time.Second)), nil
+}
@@ -0,0 +1,26 @@
+package common
+
+import "fmt"
+
+// Dict : dict 関数。キー、値の組からマップを作成する
+// ex) {{partial "card.html" (dict "Title" .Title "Items" .Items)}}
+func Dict(pairs ...interface{}) (map[string]interface{}, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict: odd number of arguments %d", len(pairs))
+	}
+	var result = make(map[string]interface{}, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not string", pairs[i])
+		}
+		result[key] = pairs[i+1]
+	}
+	return result, nil
+}
+
+// List : list 関数。引数からスライスを作成する
+// ex) {{range list "a" "b" "c"}}{{.}}{{end}}
+func List(items ...interface{}) []interface{} {
+	return append([]interface{}{}, items...)
+}
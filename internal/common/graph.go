@@ -0,0 +1,311 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+	"unicode"
+)
+
+// Edge : テンプレート間の参照
+type Edge struct {
+	From   string `json:"from"`   // 参照元のテンプレート名
+	To     string `json:"to"`     // 参照先のテンプレート名。解決できない場合は、import, hastemplate の書式
+	Kind   string `json:"kind"`   // import, hastemplate, partial, layout, cache, template, block
+	Line   int    `json:"line"`   // 参照元の行番号
+	Column int    `json:"column"` // 参照元のカラム番号
+}
+
+// Graph : テンプレートの依存関係を表す有向グラフ
+type Graph struct {
+	Nodes      []string            // 読み込んだテンプレート名(ファイル名、及び define, block で定義したテンプレート名)
+	Edges      []Edge              // テンプレート間の参照
+	Unresolved []Edge              // 書式の引数が定数ではないため、参照先を解決できない import, hastemplate
+	files      map[string][]string // テンプレート名毎の、定義したファイル名
+	uses       map[string][]Edge   // 参照元毎の参照
+	usedby     map[string][]Edge   // 参照先毎の参照
+}
+
+// NewGraph : 空の Graph を生成する
+func NewGraph() *Graph {
+	return &Graph{files: make(map[string][]string), uses: make(map[string][]Edge), usedby: make(map[string][]Edge)}
+}
+
+// Add : テンプレートファイルをパースし、ファイル内のテンプレートと参照を追加する
+func (g *Graph) Add(name, text string, delims Delims) error {
+	trees, err := Parse(name, text, delims)
+	if err != nil {
+		return RenderError(err, nil, text)
+	}
+	var names []string
+	for k := range trees {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		tree := trees[k]
+		// レイアウトで上書きする define 等、同じ名前のテンプレートは、複数のファイルで定義される
+		if _, ok := g.files[tree.Name]; !ok {
+			g.Nodes = append(g.Nodes, tree.Name)
+		}
+		g.files[tree.Name] = append(g.files[tree.Name], name)
+		Walk(tree.Root, func(node parse.Node) {
+			edge, ok := references(text, node)
+			if !ok {
+				return
+			}
+			pos := Position(tree, node)
+			edge.From, edge.Line, edge.Column = tree.Name, pos.Line, pos.Column
+			if edge.To == "" {
+				return
+			}
+			if edge.Kind == "import" || edge.Kind == "hastemplate" {
+				if to, ok := resolve(node.(*parse.CommandNode).Args[1:]); ok {
+					edge.To = to
+				} else {
+					g.Unresolved = append(g.Unresolved, edge)
+					return
+				}
+			}
+			g.Edges = append(g.Edges, edge)
+			g.uses[edge.From] = append(g.uses[edge.From], edge)
+			g.usedby[edge.To] = append(g.usedby[edge.To], edge)
+		})
+	}
+	sort.Strings(g.Nodes)
+	return nil
+}
+
+// ノードが参照の場合は、参照の種類と参照先を取得する。import, hastemplate の場合は、参照先に書式を格納する
+func references(text string, node parse.Node) (Edge, bool) {
+	switch node := node.(type) {
+	case *parse.TemplateNode:
+		// block はパース時に template へ置き換えられるため、元の文字列から判定する
+		kind := "template"
+		if pos := int(node.Position()); pos <= len(text) && strings.HasSuffix(strings.TrimRightFunc(text[:pos], unicode.IsSpace), "block") {
+			kind = "block"
+		}
+		return Edge{To: node.Name, Kind: kind}, true
+	case *parse.CommandNode:
+		if len(node.Args) < 2 {
+			return Edge{}, false
+		}
+		ident, ok := node.Args[0].(*parse.IdentifierNode)
+		if !ok {
+			return Edge{}, false
+		}
+		switch ident.Ident {
+		case "import", "hastemplate":
+			if str, ok := node.Args[1].(*parse.StringNode); ok {
+				return Edge{To: str.Text, Kind: ident.Ident}, true
+			}
+		case "partial", "layout", "cache":
+			if name, ok := reference(node); ok {
+				return Edge{To: name, Kind: ident.Ident}, true
+			}
+		}
+	}
+	return Edge{}, false
+}
+
+// import, hastemplate の書式と引数が全て定数の場合は、テンプレート名を作成する
+func resolve(args []parse.Node) (string, bool) {
+	format := args[0].(*parse.StringNode).Text
+	var values []interface{}
+	for _, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *parse.StringNode:
+			values = append(values, arg.Text)
+		case *parse.NumberNode:
+			switch {
+			case arg.IsInt:
+				values = append(values, arg.Int64)
+			case arg.IsFloat:
+				values = append(values, arg.Float64)
+			default:
+				return "", false
+			}
+		case *parse.BoolNode:
+			values = append(values, arg.True)
+		default:
+			return "", false
+		}
+	}
+	if len(values) == 0 {
+		return format, true
+	}
+	return fmt.Sprintf(format, values...), true
+}
+
+// Files : テンプレートを定義したファイル名を返却する
+func (g *Graph) Files(name string) []string {
+	return g.files[name]
+}
+
+// Uses : 指定したテンプレートが直接参照しているテンプレート名を返却する
+func (g *Graph) Uses(name string) []string {
+	var result []string
+	for _, edge := range g.uses[name] {
+		result = append(result, edge.To)
+	}
+	return unique(result)
+}
+
+// UsedBy : 指定したテンプレートを直接参照しているテンプレート名を返却する
+func (g *Graph) UsedBy(name string) []string {
+	var result []string
+	for _, edge := range g.usedby[name] {
+		result = append(result, edge.From)
+	}
+	return unique(result)
+}
+
+// Dependents : 指定したテンプレート、またはファイルを変更した場合に影響を受ける、全てのテンプレート名を返却する
+// ファイル名を指定した場合は、ファイル内で定義したテンプレートを参照しているテンプレートも対象とする
+func (g *Graph) Dependents(name string) []string {
+	var queue = []string{name}
+	var found = map[string]bool{name: true}
+	for k, files := range g.files {
+		for _, file := range files {
+			if file == name && !found[k] {
+				found[k] = true
+				queue = append(queue, k)
+			}
+		}
+	}
+	var result []string
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, from := range g.UsedBy(current) {
+			if !found[from] {
+				found[from] = true
+				result = append(result, from)
+				queue = append(queue, from)
+			}
+		}
+	}
+	return unique(result)
+}
+
+// Cycles : 循環している参照を、テンプレート名の一覧毎に返却する
+func (g *Graph) Cycles() [][]string {
+	// Tarjan のアルゴリズムで、強連結成分を求める
+	var index = make(map[string]int)
+	var lowlink = make(map[string]int)
+	var onstack = make(map[string]bool)
+	var stack []string
+	var result [][]string
+	var visit func(string)
+	visit = func(v string) {
+		index[v] = len(index)
+		lowlink[v] = index[v]
+		stack = append(stack, v)
+		onstack[v] = true
+		for _, w := range g.Uses(v) {
+			if _, ok := index[w]; !ok {
+				visit(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onstack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+		if lowlink[v] != index[v] {
+			return
+		}
+		var component []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onstack[w] = false
+			component = append(component, w)
+			if w == v {
+				break
+			}
+		}
+		// 自身を参照している場合も、循環として扱う
+		if len(component) > 1 || g.selfLoop(v) {
+			sort.Strings(component)
+			result = append(result, component)
+		}
+	}
+	for _, v := range g.Nodes {
+		if _, ok := index[v]; !ok {
+			visit(v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i][0] < result[j][0] })
+	return result
+}
+
+func (g *Graph) selfLoop(name string) bool {
+	for _, to := range g.Uses(name) {
+		if to == name {
+			return true
+		}
+	}
+	return false
+}
+
+// DOT : Graphviz の DOT 形式で出力する。存在しないテンプレートは、破線で出力する
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph templates {\n")
+	var exists = make(map[string]bool)
+	for _, name := range g.Nodes {
+		exists[name] = true
+		fmt.Fprintf(&b, "  %s;\n", strconv.Quote(name))
+	}
+	var missing = make(map[string]bool)
+	for _, edge := range g.Edges {
+		if !exists[edge.To] && !missing[edge.To] {
+			missing[edge.To] = true
+			fmt.Fprintf(&b, "  %s [style=dashed];\n", strconv.Quote(edge.To))
+		}
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s [label=%s];\n", strconv.Quote(edge.From), strconv.Quote(edge.To), strconv.Quote(edge.Kind))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// MarshalJSON : nodes, edges, unresolved, cycles を持つ JSON を出力する
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	type node struct {
+		Name  string   `json:"name"`
+		Files []string `json:"files"`
+	}
+	var nodes = []node{}
+	for _, name := range g.Nodes {
+		nodes = append(nodes, node{Name: name, Files: g.files[name]})
+	}
+	return json.Marshal(struct {
+		Nodes      []node     `json:"nodes"`
+		Edges      []Edge     `json:"edges"`
+		Unresolved []Edge     `json:"unresolved"`
+		Cycles     [][]string `json:"cycles"`
+	}{nodes, nonNil(g.Edges), nonNil(g.Unresolved), append([][]string{}, g.Cycles()...)})
+}
+
+func nonNil(edges []Edge) []Edge {
+	if edges == nil {
+		return []Edge{}
+	}
+	return edges
+}
+
+// 重複を削除し、並べ替える
+func unique(list []string) []string {
+	sort.Strings(list)
+	var result []string
+	for i, v := range list {
+		if i == 0 || list[i-1] != v {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package common
+
+import (
+	"text/template/parse"
+
+	"github.com/ochipin/render/core"
+)
+
+// Layout : テンプレート内で使用する layout 関数。レイアウトの指定のみを行い、何も出力しない
+func Layout(name string) string {
+	return ""
+}
+
+// LayoutOf : テンプレートの最上位に記述された {{layout "name"}} から、レイアウト名を取得する
+func LayoutOf(tree *parse.Tree) string {
+	if tree == nil || tree.Root == nil {
+		return ""
+	}
+	for _, node := range tree.Root.Nodes {
+		action, ok := node.(*parse.ActionNode)
+		if !ok || action.Pipe == nil || len(action.Pipe.Cmds) != 1 {
+			continue
+		}
+		args := action.Pipe.Cmds[0].Args
+		if len(args) != 2 {
+			continue
+		}
+		if ident, ok := args[0].(*parse.IdentifierNode); !ok || ident.Ident != "layout" {
+			continue
+		}
+		if str, ok := args[1].(*parse.StringNode); ok {
+			return str.Text
+		}
+	}
+	return ""
+}
+
+// Layouts : ページからレイアウトを辿り、外側のレイアウトから順に並べたテンプレート名の一覧を返却する
+// layout が空文字の場合は、ページに記述された {{layout "name"}} を使用する
+// lookup には、指定したテンプレート名のファイルの構文木を返却する関数を指定する
+func Layouts(name, layout string, lookup func(string) (*parse.Tree, error)) ([]string, error) {
+	var result = []string{name}
+	if layout == "" {
+		tree, err := lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		layout = LayoutOf(tree)
+	}
+	for layout != "" {
+		// 既に使用しているレイアウトが指定された場合、循環参照のためエラーとする
+		for _, v := range result {
+			if v == layout {
+				return nil, &core.TemplateError{Message: "template: layout \"" + layout + "\" is cyclic", Name: layout, Category: core.ErrCyclicLayout}
+			}
+		}
+		tree, err := lookup(layout)
+		if err != nil {
+			return nil, err
+		}
+		result = append([]string{layout}, result...)
+		layout = LayoutOf(tree)
+	}
+	return result, nil
+}
+
+// LayoutTrees : 外側のレイアウトから順に構文木を重ね合わせ、テンプレート名毎に使用する構文木を返却する
+// 内側で定義された空ではない構文木が、外側の構文木を上書きする
+func LayoutTrees(chain []string, files func(string) []*parse.Tree) []*parse.Tree {
+	var names []string
+	var result = make(map[string]*parse.Tree)
+	for _, fname := range chain {
+		for _, tree := range files(fname) {
+			old, ok := result[tree.Name]
+			if !ok {
+				names = append(names, tree.Name)
+			}
+			if !ok || !parse.IsEmptyTree(tree.Root) || old == nil {
+				result[tree.Name] = tree
+			}
+		}
+	}
+	var trees []*parse.Tree
+	for _, name := range names {
+		trees = append(trees, overridable(result[name]))
+	}
+	return trees
+}
+
+// 空の構文木は、テンプレートセットに登録済みの同名のテンプレートを上書きしないため、
+// 何も出力しないアクションを持つ構文木へ置き換える
+func overridable(tree *parse.Tree) *parse.Tree {
+	if !parse.IsEmptyTree(tree.Root) {
+		return tree
+	}
+	trees, err := Parse(tree.Name, `{{""}}`, Delims{})
+	if err != nil {
+		return tree
+	}
+	blank := trees[tree.Name]
+	blank.ParseName = tree.ParseName
+	return blank
+}
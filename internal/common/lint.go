@@ -0,0 +1,223 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"github.com/ochipin/render/core"
+)
+
+// Builtins : text/template の組み込み関数
+var Builtins = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or", "print", "printf", "println", "urlquery",
+	"eq", "ge", "gt", "le", "lt", "ne",
+}
+
+// Issue : Lint で検出した問題
+type Issue struct {
+	Name    string // レンダーファイル名
+	Line    int    // 行番号(不明な場合は 0)
+	Column  int    // カラム番号(不明な場合は 0)
+	Message string // 問題の内容
+}
+
+func (issue Issue) String() string {
+	frame := core.Frame{Name: issue.Name, Line: issue.Line, Column: issue.Column}
+	if issue.Line == 0 {
+		return issue.Name + ": " + issue.Message
+	}
+	return frame.String() + ": " + issue.Message
+}
+
+// Linter : レンダーファイルを検証する
+type Linter struct {
+	Funcs  map[string]bool // 使用可能な関数名
+	issues []Issue         // 検出した問題
+	names  map[string]bool // 読み込んだファイル名、及び define で定義したテンプレート名
+	parsed []*parse.Tree   // パースに成功した構文木
+}
+
+// NewLinter : 使用可能な関数名を指定して Linter を生成する。組み込み関数、予約済みの関数名は、指定不要
+func NewLinter(funcs ...string) *Linter {
+	var l = &Linter{Funcs: make(map[string]bool), names: make(map[string]bool)}
+	for _, list := range [][]string{Builtins, Reserved, funcs} {
+		for _, name := range list {
+			l.Funcs[name] = true
+		}
+	}
+	return l
+}
+
+// Report : 問題を追加する
+func (l *Linter) Report(name string, line, column int, message string) {
+	l.issues = append(l.issues, Issue{Name: name, Line: line, Column: column, Message: message})
+}
+
+// Add : 読み込んだファイルを追加する。テンプレートファイルの場合はパースし、パースエラーを問題として追加する
+func (l *Linter) Add(name string, text string, delims Delims, isBinary bool) {
+	l.names[name] = true
+	if isBinary {
+		return
+	}
+	trees, err := Parse(name, text, delims)
+	if err != nil {
+		var frame = core.Frame{Name: name}
+		if frames := Frames(err); len(frames) > 0 {
+			frame = frames[len(frames)-1]
+		}
+		l.Report(frame.Name, frame.Line, frame.Column, parseMessage(err.Error()))
+		return
+	}
+	for _, tree := range trees {
+		l.names[tree.Name] = true
+		l.parsed = append(l.parsed, tree)
+	}
+}
+
+// Issues : 読み込んだ全てのファイルの参照を検証し、検出した全ての問題をファイル名、行番号順に返却する
+func (l *Linter) Issues() []Issue {
+	var issues = append([]Issue(nil), l.issues...)
+	for _, tree := range l.parsed {
+		Walk(tree.Root, func(node parse.Node) {
+			for _, message := range l.check(node) {
+				frame := Position(tree, node)
+				issues = append(issues, Issue{Name: frame.Name, Line: frame.Line, Column: frame.Column, Message: message})
+			}
+		})
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		a, b := issues[i], issues[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return issues
+}
+
+// 未登録の関数の呼び出し、存在しないテンプレートの参照を検証する
+func (l *Linter) check(node parse.Node) []string {
+	var result []string
+	switch node := node.(type) {
+	case *parse.IdentifierNode:
+		if !l.Funcs[node.Ident] {
+			result = append(result, fmt.Sprintf("function %q not defined", node.Ident))
+		}
+	case *parse.TemplateNode:
+		if !l.names[node.Name] {
+			result = append(result, fmt.Sprintf("template %q not defined", node.Name))
+		}
+	case *parse.CommandNode:
+		// import, partial, layout, cache で、定数で指定されたテンプレートを検証する
+		if name, ok := reference(node); ok && !l.names[name] {
+			ident := node.Args[0].(*parse.IdentifierNode).Ident
+			result = append(result, fmt.Sprintf("%s: template %q not defined", ident, name))
+		}
+	}
+	return result
+}
+
+// コマンドが import, partial, layout, cache の場合、参照するテンプレート名を取得する
+// 書式指定付きの import 等、テンプレート名が定数ではない場合は対象外とする
+func reference(cmd *parse.CommandNode) (string, bool) {
+	if len(cmd.Args) < 2 {
+		return "", false
+	}
+	ident, ok := cmd.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return "", false
+	}
+	var index int
+	switch ident.Ident {
+	case "import":
+		if len(cmd.Args) > 2 {
+			return "", false
+		}
+		index = 1
+	case "partial", "layout":
+		index = 1
+	case "cache":
+		index = 3
+	default:
+		return "", false
+	}
+	if len(cmd.Args) <= index {
+		return "", false
+	}
+	str, ok := cmd.Args[index].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return str.Text, true
+}
+
+// Walk : 構文木のノードを順に辿る
+func Walk(node parse.Node, fn func(parse.Node)) {
+	if node == nil {
+		return
+	}
+	fn(node)
+	switch node := node.(type) {
+	case *parse.ListNode:
+		for _, n := range node.Nodes {
+			Walk(n, fn)
+		}
+	case *parse.ActionNode:
+		Walk(node.Pipe, fn)
+	case *parse.PipeNode:
+		for _, cmd := range node.Cmds {
+			Walk(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range node.Args {
+			Walk(arg, fn)
+		}
+	case *parse.ChainNode:
+		Walk(node.Node, fn)
+	case *parse.IfNode:
+		walkBranch(&node.BranchNode, fn)
+	case *parse.RangeNode:
+		walkBranch(&node.BranchNode, fn)
+	case *parse.WithNode:
+		walkBranch(&node.BranchNode, fn)
+	case *parse.TemplateNode:
+		if node.Pipe != nil {
+			Walk(node.Pipe, fn)
+		}
+	}
+}
+
+func walkBranch(node *parse.BranchNode, fn func(parse.Node)) {
+	Walk(node.Pipe, fn)
+	Walk(node.List, fn)
+	if node.ElseList != nil {
+		Walk(node.ElseList, fn)
+	}
+}
+
+// Position : ノードの位置を取得する
+func Position(tree *parse.Tree, node parse.Node) core.Frame {
+	var frame = core.Frame{Name: tree.ParseName}
+	location, _ := tree.ErrorContext(node)
+	fields := strings.Split(location, ":")
+	if n := len(fields); n >= 3 {
+		frame.Name = strings.Join(fields[:n-2], ":")
+		frame.Line, _ = strconv.Atoi(fields[n-2])
+		frame.Column, _ = strconv.Atoi(fields[n-1])
+	}
+	return frame
+}
+
+// パースエラーのエラー内容から、位置を除いたメッセージを取得する
+func parseMessage(message string) string {
+	if loc := locations.FindStringIndex(message); loc != nil && loc[0] == 0 {
+		return strings.TrimSpace(message[loc[1]:])
+	}
+	return message
+}
@@ -0,0 +1,47 @@
+package common
+
+import (
+	"path"
+	"strings"
+)
+
+// Locales : ロケールを考慮したテンプレート名の候補を、優先順に返却する
+// 例えば、index.html, ja-JP の場合は index.ja-JP.html, index.ja.html, index.html の順となる
+func Locales(name, locale string) []string {
+	var result []string
+	var ext = path.Ext(name)
+	var base = strings.TrimSuffix(name, ext)
+	for _, tag := range Tags(locale) {
+		result = append(result, base+"."+tag+ext)
+	}
+	return append(result, name)
+}
+
+// Tags : ロケールを、詳細なものから順に返却する。ja-JP の場合は ja-JP, ja の順となる
+// ロケールの "_" は "-" として扱う
+func Tags(locale string) []string {
+	var result []string
+	var tags = strings.Split(strings.ReplaceAll(locale, "_", "-"), "-")
+	for i := len(tags); i > 0; i-- {
+		tag := strings.Join(tags[:i], "-")
+		if tag == "" {
+			continue
+		}
+		result = append(result, tag)
+	}
+	return result
+}
+
+// Localize : ロケールを考慮したテンプレート名の候補のうち、最初に存在するテンプレート名を返却する
+// いずれも存在しない場合は、name をそのまま返却する
+func Localize(name, locale string, exists func(string) bool) string {
+	if locale == "" {
+		return name
+	}
+	for _, v := range Locales(name, locale) {
+		if exists(v) {
+			return v
+		}
+	}
+	return name
+}
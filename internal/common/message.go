@@ -0,0 +1,305 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/ochipin/render/core"
+)
+
+// Catalog : 1つのロケールのメッセージカタログ。キーは "." で連結したメッセージ名
+type Catalog map[string]Message
+
+// Message : 複数形の種類(zero, one, two, few, many, other)毎のメッセージ
+// 複数形を持たないメッセージは、other のみを持つ
+type Message map[string]string
+
+// 複数形の種類
+var plurals = map[string]bool{"zero": true, "one": true, "two": true, "few": true, "many": true, "other": true}
+
+// ParseCatalog : JSON 形式のメッセージカタログを解析する
+// 入れ子のオブジェクトは "." で連結したキーとし、複数形の種類のみをキーに持つオブジェクトは、複数形のメッセージとして扱う
+func ParseCatalog(name string, buf []byte) (Catalog, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(buf, &root); err != nil {
+		return nil, &core.TemplateError{Message: "message: " + name + ": " + err.Error(), Name: name, Category: core.ErrCatalog, Err: err}
+	}
+	var catalog = make(Catalog)
+	if err := flatten(catalog, "", root); err != nil {
+		return nil, &core.TemplateError{Message: "message: " + name + ": " + err.Error(), Name: name, Category: core.ErrCatalog, Err: err}
+	}
+	return catalog, nil
+}
+
+// 入れ子のオブジェクトを、"." で連結したキーのメッセージへ変換する
+func flatten(catalog Catalog, prefix string, values map[string]interface{}) error {
+	for k, v := range values {
+		key := prefix + k
+		switch v := v.(type) {
+		case string:
+			catalog[key] = Message{"other": v}
+		case map[string]interface{}:
+			if msg, ok := pluralMessage(v); ok {
+				catalog[key] = msg
+				continue
+			}
+			if err := flatten(catalog, key+".", v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%q must be a string or an object", key)
+		}
+	}
+	return nil
+}
+
+// 複数形の種類のみをキーに持ち、other を含むオブジェクトの場合は、複数形のメッセージへ変換する
+func pluralMessage(values map[string]interface{}) (Message, bool) {
+	if _, ok := values["other"]; !ok {
+		return nil, false
+	}
+	var msg = make(Message)
+	for k, v := range values {
+		s, ok := v.(string)
+		if !ok || !plurals[k] {
+			return nil, false
+		}
+		msg[k] = s
+	}
+	return msg, true
+}
+
+// LoadCatalogs : fsys 直下の "ロケール名.json" を全て読み込む
+func LoadCatalogs(fsys fs.FS) (map[string]Catalog, error) {
+	var result = make(map[string]Catalog)
+	if fsys == nil {
+		return result, nil
+	}
+	names, err := fs.Glob(fsys, "*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		buf, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		catalog, err := ParseCatalog(name, buf)
+		if err != nil {
+			return nil, err
+		}
+		result[strings.TrimSuffix(name, path.Ext(name))] = catalog
+	}
+	return result, nil
+}
+
+// LoadCatalog : fsys 直下の "ロケール名.json" を読み込む。ファイルが存在しない場合は、空のカタログを返却する
+func LoadCatalog(fsys fs.FS, locale string) (Catalog, error) {
+	if fsys == nil {
+		return nil, nil
+	}
+	name := locale + ".json"
+	buf, err := fs.ReadFile(fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseCatalog(name, buf)
+}
+
+// Translator : レンダー毎に、ロケールに一致するメッセージを取得する
+type Translator struct {
+	lang    string                        // 複数形の判定に使用する言語
+	locales []string                      // カタログの探索順
+	load    func(string) (Catalog, error) // 指定したロケールのカタログを取得する関数
+	loaded  map[string]Catalog            // 読み込み済みのカタログ
+}
+
+// NewTranslator : locale, fallback の順にカタログを探索する Translator を生成する
+// locale が空文字の場合は、fallback を使用する
+func NewTranslator(locale, fallback string, load func(string) (Catalog, error)) *Translator {
+	if locale == "" {
+		locale = fallback
+	}
+	var locales []string
+	var found = make(map[string]bool)
+	for _, tag := range append(Tags(locale), Tags(fallback)...) {
+		if !found[tag] {
+			found[tag] = true
+			locales = append(locales, tag)
+		}
+	}
+	var lang string
+	if tags := Tags(locale); len(tags) > 0 {
+		lang = tags[len(tags)-1]
+	}
+	return &Translator{
+		lang:    lang,
+		locales: locales,
+		load:    load,
+		loaded:  make(map[string]Catalog),
+	}
+}
+
+// T : テンプレート内で使用する t 関数。指定したキーのメッセージを取得する
+// メッセージ内の {name} は、引数に指定した名前と値の組、または map の値で置き換える
+// キーが見つからない場合は、キーをそのまま返却する
+func (t *Translator) T(key string, args ...interface{}) (string, error) {
+	values, err := messageArgs(args)
+	if err != nil {
+		return "", err
+	}
+	msg, err := t.message(key)
+	if err != nil || msg == nil {
+		return key, err
+	}
+	return interpolate(msg["other"], values), nil
+}
+
+// TN : テンプレート内で使用する tn 関数。count に応じた複数形のメッセージを取得する
+// メッセージ内の {count} は、count で置き換える
+func (t *Translator) TN(key string, count interface{}, args ...interface{}) (string, error) {
+	n, err := toFloat(count)
+	if err != nil {
+		return "", err
+	}
+	values, err := messageArgs(args)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := values["count"]; !ok {
+		values["count"] = count
+	}
+	msg, err := t.message(key)
+	if err != nil || msg == nil {
+		return key, err
+	}
+	// zero が定義されている場合は、0 の時に使用する
+	var text, ok = "", false
+	if n == 0 {
+		text, ok = msg["zero"]
+	}
+	if !ok {
+		text, ok = msg[PluralCategory(t.lang, n)]
+	}
+	if !ok {
+		text = msg["other"]
+	}
+	return interpolate(text, values), nil
+}
+
+// ロケールの探索順に、指定したキーのメッセージを取得する
+func (t *Translator) message(key string) (Message, error) {
+	for _, locale := range t.locales {
+		catalog, ok := t.loaded[locale]
+		if !ok {
+			var err error
+			if catalog, err = t.load(locale); err != nil {
+				return nil, err
+			}
+			t.loaded[locale] = catalog
+		}
+		if msg, ok := catalog[key]; ok {
+			return msg, nil
+		}
+	}
+	return nil, nil
+}
+
+// t, tn の引数を、名前と値の組へ変換する
+func messageArgs(args []interface{}) (map[string]interface{}, error) {
+	if len(args) == 1 {
+		if v, ok := args[0].(map[string]interface{}); ok {
+			var result = make(map[string]interface{})
+			for k, v := range v {
+				result[k] = v
+			}
+			return result, nil
+		}
+	}
+	return Dict(args...)
+}
+
+var placeholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// メッセージ内の {name} を、値で置き換える。値が指定されていない場合は、そのまま出力する
+func interpolate(text string, values map[string]interface{}) string {
+	return placeholder.ReplaceAllStringFunc(text, func(s string) string {
+		if v, ok := values[s[1:len(s)-1]]; ok {
+			return fmt.Sprint(v)
+		}
+		return s
+	})
+}
+
+// 数値を float64 へ変換する
+func toFloat(i interface{}) (float64, error) {
+	v := reflect.ValueOf(i)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	}
+	return 0, fmt.Errorf("tn: count must be a number, got %T", i)
+}
+
+// PluralCategory : 言語毎の規則に従い、数値に対応する複数形の種類を返却する
+// 整数以外の数値は、other として扱う
+func PluralCategory(lang string, n float64) string {
+	if n != float64(int64(n)) {
+		return "other"
+	}
+	i := int64(n)
+	if i < 0 {
+		i = -i
+	}
+	switch lang {
+	// 複数形を区別しない言語
+	case "ja", "zh", "ko", "th", "vi", "id", "ms", "lo", "my", "km":
+		return "other"
+	case "fr", "pt":
+		if i == 0 || i == 1 {
+			return "one"
+		}
+		return "other"
+	case "ru", "uk", "be":
+		switch {
+		case i%10 == 1 && i%100 != 11:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		}
+		return "many"
+	case "pl":
+		switch {
+		case i == 1:
+			return "one"
+		case i%10 >= 2 && i%10 <= 4 && (i%100 < 12 || i%100 > 14):
+			return "few"
+		}
+		return "many"
+	case "cs", "sk":
+		switch {
+		case i == 1:
+			return "one"
+		case i >= 2 && i <= 4:
+			return "few"
+		}
+		return "other"
+	}
+	if i == 1 {
+		return "one"
+	}
+	return "other"
+}
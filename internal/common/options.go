@@ -0,0 +1,70 @@
+package common
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/ochipin/render/core"
+)
+
+// Options : レンダー毎に指定するオプション
+type Options struct {
+	Layout string // 使用するレイアウト名。空文字の場合は {{layout "name"}} で指定したレイアウトを使用する
+	Locale string // ロケール名。空文字の場合はロケールを考慮しない
+	Trace  *Trace // 解析中に読み込んだテンプレートの記録先。nil の場合は記録しない
+}
+
+// Trace : レンダー中に import, partial で読み込んだテンプレートを記録する
+type Trace struct {
+	mu      sync.Mutex
+	imports []string
+}
+
+// Import : 読み込んだテンプレート名を記録する。記録済みのテンプレート名は記録しない
+func (t *Trace) Import(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, v := range t.imports {
+		if v == name {
+			return
+		}
+	}
+	t.imports = append(t.imports, name)
+}
+
+// Imports : 読み込んだテンプレート名を、読み込んだ順に返却する
+func (t *Trace) Imports() []string {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.imports...)
+}
+
+// ContentType : 拡張子から Content-Type を判定する。判定できない場合は、内容から判定する
+func ContentType(name string, body []byte) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+	return http.DetectContentType(body)
+}
+
+// Result : 解析結果と、ファイルの情報から core.Result を作成する
+func Result(info *core.FileInfo, origin string, body []byte, trace *Trace) *core.Result {
+	return &core.Result{
+		Body:        body,
+		Name:        info.Name,
+		Path:        path.Join(origin, info.Name),
+		ContentType: ContentType(info.Name, body),
+		IsBinary:    info.IsBinary,
+		ModTime:     info.ModTime,
+		Size:        int64(len(body)),
+		Imports:     trace.Imports(),
+	}
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"errors"
+	"io/fs"
+	"sort"
+)
+
+// RootFS : 複数のレンダー対象ディレクトリを、先頭から順に探索する fs.FS
+// 同じ名前のファイルが複数のディレクトリに存在する場合は、先頭に近いディレクトリのファイルを使用する
+type RootFS struct {
+	names []string
+	roots []fs.FS
+}
+
+// NewRootFS : ディレクトリ名と、そのディレクトリの fs.FS から RootFS を生成する
+func NewRootFS(names []string, roots []fs.FS) *RootFS {
+	return &RootFS{names: names, roots: roots}
+}
+
+// Open : 先頭のディレクトリから順にファイルを探索し、最初に見つかったファイルを開く
+func (r *RootFS) Open(name string) (fs.File, error) {
+	i, err := r.find(name)
+	if err != nil {
+		return nil, err
+	}
+	return r.roots[i].Open(name)
+}
+
+// ReadDir : 全ディレクトリの同名のディレクトリ配下のファイル一覧を、先頭のディレクトリを優先して結合する
+func (r *RootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	var result []fs.DirEntry
+	var found = make(map[string]bool)
+	var exists bool
+	for _, root := range r.roots {
+		entries, err := fs.ReadDir(root, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		exists = true
+		for _, entry := range entries {
+			if found[entry.Name()] {
+				continue
+			}
+			found[entry.Name()] = true
+			result = append(result, entry)
+		}
+	}
+	if !exists {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name() < result[j].Name()
+	})
+	return result, nil
+}
+
+// Origin : 指定したファイルが見つかったディレクトリ名を取得する
+func (r *RootFS) Origin(name string) (string, error) {
+	i, err := r.find(name)
+	if err != nil {
+		return "", err
+	}
+	return r.names[i], nil
+}
+
+// 指定したファイルが存在する、先頭に最も近いディレクトリの位置を取得する
+func (r *RootFS) find(name string) (int, error) {
+	if !fs.ValidPath(name) {
+		return 0, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for i, root := range r.roots {
+		_, err := fs.Stat(root, name)
+		if err == nil {
+			return i, nil
+		}
+		// 存在しない以外のエラーは、そのまま返却する
+		if !errors.Is(err, fs.ErrNotExist) {
+			return 0, err
+		}
+	}
+	return 0, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// Template : text/template, html/template を共通で扱うためのインタフェース
+type Template interface {
+	// 構文木をテンプレートセットへ登録する
+	AddParseTree(name string, tree *parse.Tree) error
+	// ヘルパ関数を登録する
+	Funcs(funcs template.FuncMap) Template
+	// テンプレートセットを複製する
+	Clone() (Template, error)
+	// 指定した名前の構文木を取得する。存在しない場合は nil を返却する
+	Lookup(name string) *parse.Tree
+	// 指定した名前のテンプレートを実行する
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+	// import の実行結果を、テンプレートへ埋め込む値へ変換する
+	Safe(text string) interface{}
+	// html/template による自動エスケープが有効な場合 true を返却する
+	Escape() bool
+}
+
+// NewTemplate : 空のテンプレートセットを生成する。escape が true の場合は html/template を使用する
+func NewTemplate(escape bool) Template {
+	if escape {
+		return &htmlTemplate{htmltemplate.New("")}
+	}
+	return &textTemplate{template.New("")}
+}
+
+// IsEscape : 指定したファイル名が、自動エスケープの対象か確認する。"*" が指定されている場合は全ファイルが対象
+func IsEscape(fname string, escape []string) bool {
+	for _, ext := range escape {
+		if ext == "*" || strings.HasSuffix(fname, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// text/template を管理する構造体
+type textTemplate struct {
+	tmpl *template.Template
+}
+
+func (t *textTemplate) AddParseTree(name string, tree *parse.Tree) error {
+	_, err := t.tmpl.AddParseTree(name, tree)
+	return err
+}
+
+func (t *textTemplate) Funcs(funcs template.FuncMap) Template {
+	t.tmpl.Funcs(funcs)
+	return t
+}
+
+func (t *textTemplate) Clone() (Template, error) {
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &textTemplate{tmpl}, nil
+}
+
+func (t *textTemplate) Lookup(name string) *parse.Tree {
+	if tmpl := t.tmpl.Lookup(name); tmpl != nil {
+		return tmpl.Tree
+	}
+	return nil
+}
+
+func (t *textTemplate) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	return t.tmpl.ExecuteTemplate(w, name, data)
+}
+
+func (t *textTemplate) Safe(text string) interface{} {
+	return text
+}
+
+func (t *textTemplate) Escape() bool {
+	return false
+}
+
+// html/template を管理する構造体
+type htmlTemplate struct {
+	tmpl *htmltemplate.Template
+}
+
+func (t *htmlTemplate) AddParseTree(name string, tree *parse.Tree) error {
+	// html/template はエスケープ処理で構文木を書き換えるため、複製した構文木を登録する
+	_, err := t.tmpl.AddParseTree(name, tree.Copy())
+	return err
+}
+
+func (t *htmlTemplate) Funcs(funcs template.FuncMap) Template {
+	t.tmpl.Funcs(funcs)
+	return t
+}
+
+func (t *htmlTemplate) Clone() (Template, error) {
+	tmpl, err := t.tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &htmlTemplate{tmpl}, nil
+}
+
+func (t *htmlTemplate) Lookup(name string) *parse.Tree {
+	if tmpl := t.tmpl.Lookup(name); tmpl != nil {
+		return tmpl.Tree
+	}
+	return nil
+}
+
+func (t *htmlTemplate) ExecuteTemplate(w io.Writer, name string, data interface{}) error {
+	return t.tmpl.ExecuteTemplate(w, name, data)
+}
+
+func (t *htmlTemplate) Safe(text string) interface{} {
+	// import 先で既にエスケープ済みのため、再度エスケープされないようにする
+	return htmltemplate.HTML(text)
+}
+
+func (t *htmlTemplate) Escape() bool {
+	return true
+}
@@ -2,19 +2,23 @@ package nocache
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
 	"os"
 	"regexp"
 	"sync"
 	"text/template"
+	"text/template/parse"
 
 	"github.com/ochipin/render/core"
 	"github.com/ochipin/render/internal/common"
 )
 
-// Template : 独自実装のテンプレート
+// Template : 独自実装のテンプレート。実行時は複製したテンプレートセットを使用する
 type Template struct {
-	*template.Template
+	common.Template
 	funcs template.FuncMap
 }
 
@@ -22,10 +26,17 @@ type Template struct {
 type Render struct {
 	mu        sync.Mutex
 	directory string
+	fsys      fs.FS // レンダーファイルの読み込み元
 	targets   []string
 	exclude   *regexp.Regexp
+	escape    []string
 	binary    bool
 	maxsize   int64
+	delimiter common.Delims            // テンプレートの区切り文字
+	extdelims map[string]common.Delims // 拡張子毎のテンプレートの区切り文字
+	messages  fs.FS                    // メッセージカタログの読み込み元
+	locale    string                   // ロケール未指定時に使用するロケール
+	fragments *common.Fragments        // cache 関数で保持する解析結果。コピー元と共有する
 	funcs     template.FuncMap
 }
 
@@ -39,10 +50,17 @@ func (r *Render) Copy() core.Render {
 	// レンダーオブジェクトを返却する
 	return &Render{
 		directory: r.directory,
+		fsys:      r.fsys,
 		targets:   r.targets,
 		exclude:   r.exclude,
+		escape:    r.escape,
 		binary:    r.binary,
 		maxsize:   r.maxsize,
+		delimiter: r.delimiter,
+		extdelims: r.extdelims,
+		messages:  r.messages,
+		locale:    r.locale,
+		fragments: r.fragments,
 		funcs:     funcs,
 	}
 }
@@ -88,70 +106,223 @@ func (r *Render) AddHelper(helper template.FuncMap) error {
 // RenderString : 文字列レンダーを処理する
 func (r *Render) RenderString(text string, data interface{}) ([]byte, error) {
 	// レンダーファイルの場合はパース開始
-	tmpl, err := r.template("string", []byte(text), data)
+	tmpl := r.template(context.Background(), common.IsEscape("*", r.escape), data, common.Options{})
 	// パースエラーが発生した場合は、エラーを返却する
-	if err != nil {
+	if err := r.parse(tmpl, "string", []byte(text)); err != nil {
 		return nil, err
 	}
 	// パースデータを実行する
-	return r.execute(tmpl, "string", data)
+	buf, err := r.execute(context.Background(), tmpl, "string", data)
+	return buf, common.Diagnose(err, func(name string) (string, bool) {
+		if name == "string" {
+			return text, true
+		}
+		return r.source(name)
+	})
 }
 
 // Render : 指定した名前でデータでテンプレートファイルの解析結果を取得する
 func (r *Render) Render(tmplname string, data interface{}) ([]byte, error) {
-	// 指定されたファイル名をリードする
-	buf, isBinary, err := r.readfile(tmplname)
+	return r.RenderContext(context.Background(), tmplname, data)
+}
+
+// RenderContext : コンテキストを指定して、テンプレートファイルの解析結果を取得する
+func (r *Render) RenderContext(ctx context.Context, tmplname string, data interface{}) ([]byte, error) {
+	// 解析結果をバッファへ格納し、返却する
+	var buf bytes.Buffer
+	if err := r.render(ctx, &buf, common.Options{}, tmplname, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderTo : 指定した名前でデータでテンプレートファイルの解析結果を w へ書き込む
+// バイナリファイルは、ディスクから直接 w へ書き込む。テンプレートファイルは、未読み込みのテンプレートを
+// 読み込み後に再実行する場合があるため、解析結果を一旦バッファへ保持してから書き込む
+func (r *Render) RenderTo(w io.Writer, tmplname string, data interface{}) error {
+	return r.render(context.Background(), w, common.Options{}, tmplname, data)
+}
+
+// RenderWithLayout : 指定したレイアウトの中に、テンプレートファイルの解析結果を埋め込む
+func (r *Render) RenderWithLayout(layout, tmplname string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.render(context.Background(), &buf, common.Options{Layout: layout}, tmplname, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderResult : 指定した名前でデータでテンプレートファイルの解析結果と、ファイルの情報を取得する
+func (r *Render) RenderResult(tmplname string, data interface{}) (*core.Result, error) {
+	var buf bytes.Buffer
+	var trace = &common.Trace{}
+	if err := r.render(context.Background(), &buf, common.Options{Trace: trace}, tmplname, data); err != nil {
+		return nil, err
+	}
+	info, err := r.Stat(tmplname)
+	if err != nil {
+		return nil, err
+	}
+	origin, err := r.Origin(tmplname)
 	if err != nil {
 		return nil, err
 	}
-	// バイナリファイルの場合は、バイナリデータを返却する
+	return common.Result(info, origin, buf.Bytes(), trace), nil
+}
+
+// RenderLocale : ロケールを考慮したテンプレートファイルの解析結果を取得する
+// index.html, ja-JP の場合は index.ja-JP.html, index.ja.html, index.html の順に探索する
+func (r *Render) RenderLocale(tmplname, locale string, data interface{}) ([]byte, error) {
+	return r.RenderLocaleContext(context.Background(), tmplname, locale, data)
+}
+
+// RenderLocaleContext : コンテキストを指定して、ロケールを考慮したテンプレートファイルの解析結果を取得する
+func (r *Render) RenderLocaleContext(ctx context.Context, tmplname, locale string, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := r.render(ctx, &buf, common.Options{Locale: locale}, tmplname, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 指定した名前のバイナリファイル、またはテンプレートファイルの解析結果を w へ書き込む
+// レイアウトが空文字の場合は、テンプレートファイルに記述された {{layout "name"}} のレイアウトを使用する
+func (r *Render) render(ctx context.Context, w io.Writer, opts common.Options, tmplname string, data interface{}) error {
+	// ロケールが指定されている場合は、ロケールに一致するファイルを使用する
+	tmplname = common.Localize(tmplname, opts.Locale, r.exists)
+	// 指定されたファイル名をオープンする
+	file, isBinary, err := r.open(tmplname)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// バイナリファイルの場合は、バイナリデータを書き込む
 	if isBinary {
-		return buf, nil
+		_, err := file.WriteTo(w)
+		return err
 	}
 	// レンダーファイルの場合はパース開始
-	tmpl, err := r.template(tmplname, buf, data)
+	buf := file.ReadAll()
+	page, err := common.Parse(tmplname, string(buf), r.delims(tmplname))
 	// パースエラーが発生した場合は、エラーを返却する
 	if err != nil {
-		return nil, err
+		return common.RenderError(err, nil, string(buf))
+	}
+	common.Interruptible(page, string(buf), r.delims(tmplname))
+	// レイアウトを外側から順に読み込む
+	var files = map[string]map[string]*parse.Tree{tmplname: page}
+	chain, err := common.Layouts(tmplname, opts.Layout, func(name string) (*parse.Tree, error) {
+		if _, ok := files[name]; !ok {
+			trees, err := r.load(name)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = trees
+		}
+		return files[name][name], nil
+	})
+	if err != nil {
+		return err
+	}
+	// 外側のレイアウトから順に構文木を登録し、内側で定義した define でブロックを上書きする
+	// 自動エスケープの対象の場合は、html/template を使用する
+	tmpl := r.template(ctx, common.IsEscape(tmplname, r.escape), data, opts)
+	trees := common.LayoutTrees(chain, func(name string) []*parse.Tree {
+		var result []*parse.Tree
+		for _, tree := range files[name] {
+			result = append(result, tree)
+		}
+		return result
+	})
+	for _, tree := range trees {
+		if err := tmpl.AddParseTree(tree.Name, tree); err != nil {
+			return common.RenderError(err, nil, "")
+		}
+	}
+	// 最も外側のレイアウトからパースデータを実行し、結果を書き込む
+	buf, err = r.execute(ctx, tmpl, chain[0], data)
+	if err != nil {
+		return common.Diagnose(err, r.source)
 	}
+	_, err = w.Write(buf)
+	return err
+}
 
-	// パースデータを実行する
-	return r.execute(tmpl, tmplname, data)
+// 指定した名前のファイルの元の内容を取得する。エラー発生時のみ使用するため、その都度読み込む
+func (r *Render) source(name string) (string, bool) {
+	buf, isBinary, err := r.readfile(name)
+	if err != nil || isBinary {
+		return "", false
+	}
+	return string(buf), true
 }
 
 // 指定した名前のファイルを読み込み、データを返却する。バイナリの場合は、2つの目の復帰値が true になる
 func (r *Render) readfile(name string) ([]byte, bool, error) {
+	file, isBinary, err := r.open(name)
+	if err != nil {
+		return nil, isBinary, err
+	}
+	defer file.Close()
+	return file.ReadAll(), isBinary, nil
+}
+
+// 指定した名前のファイルをオープンする。バイナリの場合は、2つの目の復帰値が true になる
+func (r *Render) open(name string) (*common.Buf, bool, error) {
 	// 登録済みの拡張子と一致しない場合は、エラーを返却する
 	if common.HasSuffix(name, r.targets) == false {
-		return nil, false, &core.TemplateError{Message: "template: \"" + name + "\" not defined"}
+		return nil, false, common.NotDefined(name, core.ErrNotFound)
 	}
 
 	// ファイルを読み込む
-	file, err := common.ReadFile(fmt.Sprintf("%s/%s", r.directory, name))
+	file, err := common.OpenFile(r.fsys, name)
 	// ファイルが存在しない、またはパーミッション等の理由でファイル読み込みが出来ない場合は、エラーとする
 	if err != nil {
-		return nil, false, &core.TemplateError{Message: "template: " + err.Error()}
+		return nil, false, common.FileError(name, err)
 	}
-	defer file.Close()
 
 	// バイナリファイルを対象としていない場合、エラーとする
 	isBinary := file.IsBinary()
 	if r.binary == false && isBinary {
-		return nil, isBinary, &core.TemplateError{Message: "template: \"" + name + "\" not defined"}
+		file.Close()
+		return nil, isBinary, common.NotDefined(name, core.ErrBinaryDisabled)
 	}
 
 	// ファイルサイズ設定値を超過していた場合、エラーを返却する
-	if r.maxsize > 0 && file.Size() > r.maxsize {
-		return nil, false, &core.TemplateError{
-			Message: fmt.Sprintf("%s: %d < %d. maxsize over", name, r.maxsize, file.Size()),
-		}
+	if size := file.Size(); r.maxsize > 0 && size > r.maxsize {
+		file.Close()
+		return nil, false, common.TooLarge(name, r.maxsize, size)
 	}
 
-	return file.ReadAll(), isBinary, nil
+	return file, isBinary, nil
+}
+
+// テンプレートファイルを読み込み、構文木を作成する
+func (r *Render) load(name string) (map[string]*parse.Tree, error) {
+	buf, isBinary, err := r.readfile(name)
+	if err != nil {
+		return nil, err
+	}
+	// バイナリファイルは、テンプレートとして扱わない
+	if isBinary {
+		return nil, common.NotDefined(name, core.ErrNotFound)
+	}
+	trees, err := common.Parse(name, string(buf), r.delims(name))
+	if err != nil {
+		return nil, common.RenderError(err, nil, string(buf))
+	}
+	return common.Interruptible(trees, string(buf), r.delims(name)), nil
+}
+
+// 指定した名前のファイルが存在するか確認する
+func (r *Render) exists(name string) bool {
+	f, err := fs.Stat(r.fsys, name)
+	return err == nil && !f.IsDir()
 }
 
 // テンプレートオブジェクトを作成する
-func (r *Render) template(name string, buf []byte, data interface{}) (tmpl *Template, err error) {
+// ロケールが指定されている場合、import, hastemplate, partial はロケールに一致するファイルを使用する
+func (r *Render) template(ctx context.Context, escape bool, data interface{}, opts common.Options) (tmpl *Template) {
 	tmpl = &Template{
 		funcs: make(template.FuncMap),
 	}
@@ -160,78 +331,137 @@ func (r *Render) template(name string, buf []byte, data interface{}) (tmpl *Temp
 	for k, v := range r.funcs {
 		tmpl.funcs[k] = v
 	}
+	// 第1引数が context.Context 型のヘルパ関数には、ctx を渡す
+	for k, v := range common.BindContext(ctx, r.funcs) {
+		tmpl.funcs[k] = v
+	}
+	// ロケールに一致するテンプレートファイル名を取得する
+	localize := func(name string) string {
+		return common.Localize(name, opts.Locale, r.exists)
+	}
 	// import : format で指定したテンプレートファイル名を元に、テンプレートファイルの内容をロードする
-	tmpl.funcs["import"] = func(format string, i ...interface{}) (string, error) {
-		// テンプレート名を変数へ格納
-		var tmplname = format
-		if len(i) >= 1 {
-			tmplname = fmt.Sprintf(format, i...)
-		}
-		buf, err := r.execute(tmpl, tmplname, data)
-		return string(buf), err
+	tmpl.funcs["import"] = func(format string, i ...interface{}) (interface{}, error) {
+		name := localize(common.TemplateName(format, i...))
+		opts.Trace.Import(name)
+		buf, err := r.execute(ctx, tmpl, name, data)
+		return tmpl.Safe(string(buf)), nested(err)
 	}
 	// hastemplate : 指定したテンプレート名が存在するかチェックする
 	tmpl.funcs["hastemplate"] = func(format string, i ...interface{}) bool {
-		// テンプレート名を変数へ格納
-		var tmplname = format
-		if len(i) >= 1 {
-			tmplname = fmt.Sprintf(format, i...)
-		}
-		f, err := os.Stat(r.directory + "/" + tmplname)
-		if err != nil || f.IsDir() {
-			return false
+		return r.exists(localize(common.TemplateName(format, i...)))
+	}
+	// partial : 指定したテンプレートファイル名のテンプレートを、指定したデータで解析する
+	tmpl.funcs["partial"] = func(name string, arg interface{}) (interface{}, error) {
+		name = localize(name)
+		opts.Trace.Import(name)
+		buf, err := r.execute(ctx, tmpl, name, arg)
+		return tmpl.Safe(string(buf)), nested(err)
+	}
+	// cache : 指定したテンプレートファイル名のテンプレートの解析結果を、キーとキーの要素毎に ttl の間保持する
+	tmpl.funcs["cache"] = func(key string, ttl interface{}, name string, parts ...interface{}) (interface{}, error) {
+		d, err := common.TTL(ttl)
+		if err != nil {
+			return nil, err
 		}
-		return true
+		name = localize(name)
+		opts.Trace.Import(name)
+		buf, err := r.fragments.Get(key, parts, name+"\x00"+opts.Locale, d, func() (string, error) {
+			buf, err := r.execute(ctx, tmpl, name, data)
+			return string(buf), nested(err)
+		})
+		return tmpl.Safe(buf), err
+	}
+	// layout : レイアウト指定。解析時は何も出力しない
+	tmpl.funcs["layout"] = common.Layout
+	tmpl.funcs["dict"] = common.Dict
+	tmpl.funcs["list"] = common.List
+	// アクションの間で、コンテキストのキャンセルを確認する
+	tmpl.funcs[common.Checkpoint] = common.CheckContext(ctx)
+	// メッセージカタログが指定されている場合は、t, tn を登録する。カタログは使用時に読み込む
+	if r.messages != nil {
+		translator := common.NewTranslator(opts.Locale, r.locale, func(locale string) (common.Catalog, error) {
+			return common.LoadCatalog(r.messages, locale)
+		})
+		tmpl.funcs["t"] = translator.T
+		tmpl.funcs["tn"] = translator.TN
 	}
 
 	// ヘルパ関数をテンプレートオブジェクトへ登録する
-	tmpl.Template, err = template.New(name).Funcs(tmpl.funcs).Parse(string(buf))
+	tmpl.Template = common.NewTemplate(escape).Funcs(tmpl.funcs)
+	return tmpl
+}
+
+// 指定したファイル名に使用する区切り文字を取得する。文字列レンダーの場合は、全体の区切り文字を使用する
+func (r *Render) delims(name string) common.Delims {
+	if name == "string" {
+		return r.delimiter
+	}
+	return common.DelimsOf(name, r.delimiter, r.extdelims)
+}
+
+// ファイルデータをパースし、テンプレートセットへ登録する
+func (r *Render) parse(tmpl *Template, name string, buf []byte) error {
+	trees, err := common.Parse(name, string(buf), r.delims(name))
 	if err != nil {
-		return nil, common.RenderError(err, nil, string(buf))
+		return common.RenderError(err, nil, string(buf))
 	}
-	return tmpl, nil
+	for tmplname, tree := range common.Interruptible(trees, string(buf), r.delims(name)) {
+		if err := tmpl.AddParseTree(tmplname, tree); err != nil {
+			return common.RenderError(err, nil, string(buf))
+		}
+	}
+	return nil
 }
 
 // パースしたテンプレートデータを実行解析する
-func (r *Render) execute(tmpl *Template, name string, data interface{}) ([]byte, error) {
+func (r *Render) execute(ctx context.Context, tmpl *Template, name string, data interface{}) ([]byte, error) {
+	// コンテキストがキャンセルされている場合は、解析を行わない
+	if err := ctx.Err(); err != nil {
+		return nil, common.RenderError(err, nil, "")
+	}
+	// html/template は実行後のパースが出来ないため、テンプレートセットを複製して実行する
+	run, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
 	// テンプレート情報をExecuteTemplateで解析し、結果をバッファヘ格納する
 	var buf bytes.Buffer
-	err := tmpl.ExecuteTemplate(&buf, name, data)
+	err = run.ExecuteTemplate(common.ContextWriter(ctx, &buf), name, data)
 
 	if err != nil {
-		// エラーが発生した場合、RenderErrorか否かを判定する
-		rerr := common.RenderError(err, tmpl.Template, "")
-		if e, ok := rerr.(*core.RenderError); ok {
-			// RenderError 型の場合は retry する
-			if err := r.retry(tmpl, e.Target, rerr); err != nil {
-				return nil, err
-			}
-			// retry 成功時は、再度executeを実行
-			return r.execute(tmpl, name, data)
+		// エラーが発生した場合、エラー内容を生成する
+		rerr := common.RenderError(err, run, "")
+		// 存在しないテンプレートファイル以外のエラー、または読み込み済みの場合は、エラーを返却する
+		target, ok := common.MissingTemplate(err)
+		if !ok || tmpl.Lookup(target) != nil {
+			return nil, rerr
 		}
-
-		// エラー内容が、template: no template "..." not defined でないか確認する
-		regex := regexp.MustCompile(`^template: no template "([^"]*?)" .*`)
 		// ファイルが存在しない場合、リトライを試みる
-		if regex.MatchString(err.Error()) {
-			target := regex.ReplaceAllString(err.Error(), "$1")
-			e := r.retry(tmpl, target, err)
-			if e != nil {
-				// retry 失敗理由がパースエラーの場合、エラーを返却
-				if _, ok := e.(*core.RenderError); ok {
-					return nil, e
-				}
-				// ファイル読み込み等のエラーの場合、エラーを返却
-				return nil, &core.TemplateError{Message: e.Error()}
-			}
-			// retry 成功時は、再度executeを実行
-			return r.execute(tmpl, name, data)
+		if err := r.retry(tmpl, target, rerr); err != nil {
+			return nil, err
 		}
+		// retry 成功時は、再度executeを実行
+		return r.execute(ctx, tmpl, name, data)
 	}
 	// ExecuteTemplate成功の場合は、バッファに格納した情報を返却する
 	return common.Exclude(buf.String(), r.exclude), nil
 }
 
+// import, partial, cache で読み込んだテンプレートのエラーから、分類前の元のエラーを取得する
+// 読み込んだテンプレートのエラーは、キャッシュありの場合と同じく、読み込み元のテンプレートの実行エラー(ErrExec)とする
+func nested(err error) error {
+	switch e := err.(type) {
+	case *core.RenderError:
+		return e.Err
+	case *core.TemplateError:
+		if e.Err != nil {
+			return e.Err
+		}
+		return errors.New(e.Message)
+	}
+	return err
+}
+
 func (r *Render) retry(tmpl *Template, target string, err error) error {
 	// ファイルを読み込む。失敗した場合は、元のエラーを返却する
 	buf, isBinary, e := r.readfile(target)
@@ -244,22 +474,102 @@ func (r *Render) retry(tmpl *Template, target string, err error) error {
 		return err
 	}
 	// ファイルデータをパースする。パース失敗時は、パースエラー内容を返却する
-	v, err := tmpl.New(target).Parse(string(buf))
+	return r.parse(tmpl, target, buf)
+}
+
+// Reload : 何もしない。キャッシュなしの場合、ファイルは常にディスクから読み込まれる
+func (r *Render) Reload() error {
+	return nil
+}
+
+// Version : キャッシュなしの場合、バージョンを持たないため、空文字を返却する
+func (r *Render) Version() string {
+	return ""
+}
+
+// Origin : 指定した名前のファイルが存在するディレクトリを取得する
+func (r *Render) Origin(name string) (string, error) {
+	if root, ok := r.fsys.(*common.RootFS); ok {
+		if origin, err := root.Origin(name); err == nil {
+			return origin, nil
+		}
+	} else if _, err := fs.Stat(r.fsys, name); err == nil {
+		return r.directory, nil
+	}
+	return "", common.NotDefined(name, core.ErrNotFound)
+}
+
+// Stat : 指定した名前のファイルの情報を取得する。ETag はファイルの更新日時とサイズから求める
+func (r *Render) Stat(name string) (*core.FileInfo, error) {
+	file, isBinary, err := r.open(name)
 	if err != nil {
-		return common.RenderError(err, tmpl.Template, string(buf))
+		return nil, err
 	}
-	tmpl.Template = v
+	defer file.Close()
+	size, modtime := file.Size(), file.ModTime()
+	return &core.FileInfo{
+		Name:     name,
+		Size:     size,
+		ModTime:  modtime,
+		ETag:     common.WeakETag(modtime, size),
+		IsBinary: isBinary,
+	}, nil
+}
+
+// Encoded : キャッシュなしの場合、圧縮データを持たないため、常にエラーを返却する
+func (r *Render) Encoded(name, encoding string) ([]byte, error) {
+	return nil, &core.TemplateError{Message: "template: \"" + name + "\" has no " + encoding + " encoding", Name: name, Category: core.ErrNotEncoded}
+}
+
+// IsPartial : 指定した名前のファイルが、import, partial, layout, cache で読み込む部品のファイルか確認する
+// キャッシュなしの場合は、呼び出し毎に全てのテンプレートファイルを読み込み、依存関係を作成して判定する
+func (r *Render) IsPartial(name string) bool {
+	var graph = common.NewGraph()
+	fs.WalkDir(r.fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || common.HasSuffix(path, r.targets) == false {
+			return nil
+		}
+		// 読み込めないファイル、パースエラーのファイルは、解析時にエラーとなるため無視する
+		buf, isBinary, err := r.readfile(path)
+		if err == nil && !isBinary {
+			graph.Add(path, string(buf), r.delims(path))
+		}
+		return nil
+	})
+	return common.IsPartial(graph, name)
+}
+
+// Invalidate : cache 関数で保持している、指定したキーの解析結果を削除する
+// キーの要素を省略した場合は、キーが一致する全ての解析結果を削除する
+func (r *Render) Invalidate(key string, parts ...interface{}) {
+	r.fragments.Invalidate(key, parts...)
+}
+
+// Close : 何もしない。キャッシュなしの場合、ファイルは常にディスクから読み込まれる
+func (r *Render) Close() error {
 	return nil
 }
 
 // CreateRender : レンダーオブジェクトを生成する
 func CreateRender(c *common.Config) core.Render {
+	// 読み込み元が未指定の場合は、Directory 配下のファイルを読み込む
+	fsys := c.FS
+	if fsys == nil {
+		fsys = os.DirFS(c.Directory)
+	}
 	return &Render{
 		directory: c.Directory,
+		fsys:      fsys,
 		targets:   c.Targets,
 		exclude:   c.Exclude,
+		escape:    c.Escape,
 		binary:    c.Binary,
 		maxsize:   c.MaxSize,
+		delimiter: c.Delims,
+		extdelims: c.ExtDelims,
+		messages:  c.Messages,
+		locale:    c.Locale,
+		fragments: common.NewFragments(c.Fragments),
 		funcs:     make(template.FuncMap),
 	}
 }
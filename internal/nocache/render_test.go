@@ -1,7 +1,11 @@
 package nocache
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"testing"
 	"text/template"
 
@@ -279,3 +283,116 @@ func Test__RENDER_STRING(t *testing.T) {
 	}
 	fmt.Println(string(buf))
 }
+
+func Test__RENDER_ESCAPE(t *testing.T) {
+	Render := CreateRender(&common.Config{
+		Directory: "test",
+		Escape:    []string{".html"},
+		MaxSize:   1024,
+	})
+	// .html は html/template でエスケープされる
+	buf, err := Render.Render("case7/index.html", map[string]interface{}{
+		"name": "<b>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(buf), "<p>&lt;b&gt;</p>") == false {
+		t.Fatal(string(buf))
+	}
+	// .text は text/template のまま処理される
+	buf, err = Render.Render("case2/body.text", map[string]interface{}{
+		"name": "<b>",
+	})
+	if err != nil || string(buf) != "<b>" {
+		t.Fatal(string(buf))
+	}
+	// 存在しないファイルを指定した場合は、エラーとなる
+	if _, err := Render.Render("case3/index.html", nil); err == nil {
+		t.Fatal("ERROR")
+	}
+}
+
+func Test__RENDER_TO(t *testing.T) {
+	Render := MakeRender(1024, true)
+	// バイナリファイルは、ディスクから直接書き込まれる
+	var buf bytes.Buffer
+	if err := Render.RenderTo(&buf, "case3/binary.png", nil); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Render.Render("case3/binary.png", nil)
+	if bytes.Equal(buf.Bytes(), b) == false {
+		t.Fatal("ERROR")
+	}
+	// テンプレートファイルの解析結果を書き込む
+	buf.Reset()
+	if err := Render.RenderTo(&buf, "case2/body.text", map[string]interface{}{"name": "Hello World"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "Hello World" {
+		t.Fatal(buf.String())
+	}
+	// 存在しないファイルを指定した場合は、エラーとなる
+	if err := Render.RenderTo(&buf, "case3/load.html", nil); err == nil {
+		t.Fatal("ERROR")
+	}
+}
+
+type contextKey struct{}
+
+func Test__RENDER_CONTEXT(t *testing.T) {
+	Render := MakeRender(1024, true)
+	// 第1引数が context.Context 型のヘルパには、コンテキストが渡される
+	Render.AddHelper(template.FuncMap{
+		"Name": func(ctx context.Context) string {
+			return ctx.Value(contextKey{}).(string)
+		},
+	})
+	ctx := context.WithValue(context.Background(), contextKey{}, "Hello World")
+	buf, err := Render.RenderContext(ctx, "case9/index.html", nil)
+	if err != nil || string(buf) != "Hello World" {
+		t.Fatal(string(buf), err)
+	}
+	// キャンセル済みのコンテキストの場合、ContextError となる
+	cancelctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = Render.RenderContext(cancelctx, "case7/index.html", map[string]interface{}{"name": "Hello World"})
+	if _, ok := err.(*core.ContextError); !ok || errors.Is(err, context.Canceled) == false {
+		t.Fatal(err)
+	}
+}
+
+func Test__RENDER_LAYOUT(t *testing.T) {
+	Render := MakeRender(1024, true)
+	// テンプレート内の layout 指定で、レイアウトを入れ子にする
+	buf, err := Render.Render("case10/page.html", map[string]interface{}{"name": "Hello World"})
+	if err != nil || string(buf) != "<html>Page|<main>Hello World</main></html>" {
+		t.Fatal(string(buf), err)
+	}
+	// RenderWithLayout でレイアウトを指定する
+	buf, err = Render.RenderWithLayout("case10/base.html", "case10/other.html", nil)
+	if err != nil || string(buf) != "<html>default|Other</html>" {
+		t.Fatal(string(buf), err)
+	}
+	// 存在しないレイアウトを指定した場合は、エラーとなる
+	if _, err := Render.RenderWithLayout("case10/undefined.html", "case10/other.html", nil); err == nil {
+		t.Fatal("ERROR")
+	}
+	// レイアウトが循環している場合は、エラーとなる
+	if _, err := Render.Render("case10/cycle.html", nil); err == nil {
+		t.Fatal("ERROR")
+	}
+}
+
+func Test__RENDER_PARTIAL(t *testing.T) {
+	Render := MakeRender(1024, true)
+	// partial で、テンプレート毎に異なるデータを渡す
+	buf, err := Render.Render("case11/index.html", map[string]interface{}{"name": "top"})
+	if err != nil || string(buf) != "[top:a][top:b]" {
+		t.Fatal(string(buf), err)
+	}
+	// dict の引数が不正な場合は、エラーとなる
+	if _, err := Render.RenderString(`{{partial "case11/card.html" (dict "Name")}}`, nil); err == nil {
+		t.Fatal("ERROR")
+	}
+}
@@ -0,0 +1,60 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ochipin/render/core"
+	"github.com/ochipin/render/internal/common"
+)
+
+// LintIssue : Lint で検出した問題
+type LintIssue = common.Issue
+
+// Lint : Config の設定に従って全てのレンダーファイルを読み込み、問題を全て検出する
+// 検出する問題は、パースエラー、helpers に含まれない関数の呼び出し、存在しないテンプレートを指定した
+// template, import, partial, layout, cache、MaxSize, SumMaxSize の超過、読み込みに失敗したファイルとなる
+// helpers には、Helper, AddHelper 等で登録するヘルパの関数名を指定する
+func (config *Config) Lint(helpers ...string) ([]LintIssue, error) {
+	fsys, err := config.filesystem()
+	if err != nil {
+		return nil, err
+	}
+	// メッセージカタログを指定した場合は、カタログを検証し、t, tn を使用可能とする
+	var linter *common.Linter
+	if config.Messages != "" {
+		messages, err := config.messages()
+		if err != nil {
+			return nil, err
+		}
+		linter = common.NewLinter(append(helpers, common.MessageFuncs...)...)
+		if _, err := common.LoadCatalogs(messages); err != nil {
+			linter.Report(config.Messages, 0, 0, err.Error())
+		}
+	} else {
+		linter = common.NewLinter(helpers...)
+	}
+	var sumfilesize int64
+
+	// New と同じく、Targets, Binary, MaxSize に従って読み込む。読み込めないファイル等は、問題として追加する
+	err = config.walk(fsys, func(path string, f *common.File, err error) error {
+		var terr *core.TemplateError
+		switch {
+		case errors.As(err, &terr) && errors.Is(err, core.ErrTooLarge):
+			linter.Report(path, 0, 0, fmt.Sprintf("%d < %d. maxsize over", terr.Limit, terr.Size))
+		case err != nil:
+			linter.Report(path, 0, 0, err.Error())
+		default:
+			sumfilesize += int64(len(f.FileData))
+			linter.Add(path, string(f.FileData), common.DelimsOf(path, config.Delims, config.ExtDelims), f.IsBinary)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.SumMaxSize > 0 && sumfilesize > config.SumMaxSize {
+		linter.Report(config.Directory, 0, 0, fmt.Sprintf("%d < %d. sum maxsize over", config.SumMaxSize, sumfilesize))
+	}
+	return linter.Issues(), nil
+}
@@ -0,0 +1,51 @@
+package render
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func Test_CONFIG_LINT(t *testing.T) {
+	fsys := fstest.MapFS{
+		"views/index.html":  &fstest.MapFile{Data: []byte("{{layout \"layout.html\"}}\n{{define \"body\"}}{{import \"header.html\"}}{{partial \"card.html\" .}}{{end}}")},
+		"views/layout.html": &fstest.MapFile{Data: []byte(`{{block "body" .}}{{end}}{{template "footer"}}{{cache "k" 60 "nav.html" .}}`)},
+		"views/header.html": &fstest.MapFile{Data: []byte("<h1>\n  {{upper .Title}}{{lower .Title}}</h1>")},
+		"views/broken.html": &fstest.MapFile{Data: []byte("ok\n{{if .}}")},
+		"views/broken2.txt": &fstest.MapFile{Data: []byte("{{.Name")},
+		"views/large.html":  &fstest.MapFile{Data: []byte(strings.Repeat("a", 200))},
+		"views/format.html": &fstest.MapFile{Data: []byte(`{{import "%s.html" .Name}}{{t "key"}}`)},
+		"views/image.png":   &fstest.MapFile{Data: []byte{0x89, 'P', 'N', 'G', 0x00, 0x01}},
+	}
+	conf := &Config{FS: fsys, Directory: "views", MaxSize: 100}
+	issues, err := conf.Lint("upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for _, issue := range issues {
+		result = append(result, issue.String())
+	}
+	expected := []string{
+		`broken.html:2: unexpected EOF`,
+		`broken2.txt:1: unclosed action`,
+		`format.html:1:28: function "t" not defined`,
+		`header.html:2:20: function "lower" not defined`,
+		`index.html:2:43: partial: template "card.html" not defined`,
+		`large.html: 100 < 200. maxsize over`,
+		`layout.html:1:36: template "footer" not defined`,
+		`layout.html:1:48: cache: template "nav.html" not defined`,
+	}
+	if strings.Join(result, "\n") != strings.Join(expected, "\n") {
+		t.Fatal(strings.Join(result, "\n"))
+	}
+
+	// 問題がない場合は、空となる
+	issues, err = (&Config{FS: fstest.MapFS{"a.html": &fstest.MapFile{Data: []byte(`{{import "a.html"}}`)}}}).Lint()
+	if err != nil || len(issues) != 0 {
+		t.Fatal(issues, err)
+	}
+	if _, err := (&Config{FS: fsys, Directory: "undefined"}).Lint(); err == nil {
+		t.Fatal("Error")
+	}
+}